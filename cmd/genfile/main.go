@@ -7,6 +7,10 @@ import (
 	"os"
 	"strings"
 
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/chunker"
+	"github.com/zatta/tp2-p2p/internal/identity"
+	"github.com/zatta/tp2-p2p/internal/magnet"
 	"github.com/zatta/tp2-p2p/internal/metadata"
 )
 
@@ -16,6 +20,12 @@ func main() {
 	size := flag.String("size", "", "Tamanho do arquivo (ex: 10KB, 1MB, 10MB)")
 	blockSize := flag.Int("block-size", 1024, "Tamanho do bloco em bytes")
 	metadataOutput := flag.String("metadata", "", "Caminho do arquivo de metadados (padrão: <output>.meta.json)")
+	signKey := flag.String("sign-key", "", "Chave Ed25519 do publicador para assinar os metadados (opcional)")
+	merkleMode := flag.Bool("merkle", false, "Gera metadados com raiz de Merkle em vez da lista de blocos")
+	cdc := flag.Bool("cdc", false, "Metadados com blocos de tamanho variável definidos pelo conteúdo (FastCDC), com média -block-size")
+	hashName := flag.String("hash", checksum.DefaultAlgorithm, "Algoritmo de hash ("+strings.Join(checksum.Algorithms(), ", ")+")")
+	var peers stringList
+	flag.Var(&peers, "peer", "Peer (host:porta) incluído na URI de compartilhamento; pode ser repetido")
 	flag.Parse()
 
 	// Valida argumentos
@@ -31,6 +41,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	algorithm, err := checksum.Lookup(*hashName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
+		os.Exit(1)
+	}
+	if !algorithm.Cryptographic {
+		log.Printf("Aviso: %s só detecta erros acidentais; use-o apenas em redes confiáveis", algorithm.Name)
+	}
+
 	// Parse tamanho
 	fileSize, err := parseSize(*size)
 	if err != nil {
@@ -57,11 +76,52 @@ func main() {
 
 	// Gera metadados
 	log.Printf("Gerando metadados...")
-	if err := metadata.GenerateAndSave(*output, *blockSize, metaPath); err != nil {
+	options := metadata.GenerateOptions{
+		BlockSize: *blockSize,
+		Merkle:    *merkleMode,
+		Hash:      algorithm.Name,
+	}
+	if *cdc {
+		params := chunker.DefaultParams(*blockSize)
+		options.Chunking = &params
+	}
+	meta, err := metadata.Generate(*output, options)
+	if err != nil {
 		log.Fatalf("Erro ao gerar metadados: %v", err)
 	}
 
+	// Assina metadados com a chave do publicador
+	if *signKey != "" {
+		publisher, err := identity.LoadFromFile(*signKey)
+		if err != nil {
+			log.Fatalf("Erro ao carregar chave do publicador: %v", err)
+		}
+		if err := meta.Sign(publisher); err != nil {
+			log.Fatalf("Erro ao assinar metadados: %v", err)
+		}
+		log.Printf("Metadados assinados por %s", identity.EncodePublicKey(publisher.PublicKey))
+	}
+
+	if err := meta.SaveToFile(metaPath); err != nil {
+		log.Fatalf("Erro ao salvar metadados: %v", err)
+	}
+
 	log.Printf("Metadados gerados: %s", metaPath)
+	if meta.IsMerkle() {
+		log.Printf("Raiz de Merkle: %s", meta.Merkle.Root)
+	}
+
+	infoHash, err := meta.InfoHash()
+	if err != nil {
+		log.Fatalf("Erro ao calcular info hash: %v", err)
+	}
+	log.Printf("Info hash: %s", infoHash)
+
+	link := &magnet.Link{InfoHash: infoHash, Name: meta.FileName, Peers: peers}
+	if _, err := magnet.Parse(link.String()); err != nil {
+		log.Fatalf("Erro ao montar URI: %v", err)
+	}
+	log.Printf("URI: %s", link)
 	log.Printf("✓ Concluído!")
 }
 
@@ -177,3 +237,15 @@ func parseSize(sizeStr string) (int64, error) {
 
 	return size * multiplier, nil
 }
+
+// stringList permite flags repetidas
+type stringList []string
+
+func (l *stringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
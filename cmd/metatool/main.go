@@ -0,0 +1,588 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/chunker"
+	"github.com/zatta/tp2-p2p/internal/identity"
+	"github.com/zatta/tp2-p2p/internal/magnet"
+	"github.com/zatta/tp2-p2p/internal/metadata"
+)
+
+// command representa um subcomando da ferramenta
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{"generate", "Gera metadados de um arquivo ou diretório existente", runGenerate},
+	{"keygen", "Gera uma chave Ed25519 de publicador", runKeygen},
+	{"extend", "Publica uma extensão de metadados ao vivo com os blocos novos", runExtend},
+	{"sign", "Assina um arquivo de metadados", runSign},
+	{"verify", "Verifica a assinatura de um arquivo de metadados", runVerify},
+	{"validate", "Valida a consistência de um arquivo de metadados", runValidate},
+	{"convert", "Converte metadados entre os formatos JSON e binário", runConvert},
+	{"infohash", "Imprime o info hash (identificador do swarm) dos metadados", runInfoHash},
+	{"uri", "Imprime a URI p2psd para compartilhar os metadados", runURI},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Erro: comando desconhecido: %s\n", os.Args[1])
+	usage()
+	os.Exit(1)
+}
+
+// usage imprime a lista de subcomandos
+func usage() {
+	fmt.Fprintln(os.Stderr, "Uso: metatool <comando> [opções]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Comandos:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// runKeygen gera uma chave de publicador e imprime a chave pública
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	output := fs.String("output", "", "Arquivo da chave privada a ser criado")
+	fs.Parse(args)
+
+	if *output == "" {
+		return errors.New("-output é obrigatório")
+	}
+	if _, err := os.Stat(*output); err == nil {
+		return fmt.Errorf("%s já existe", *output)
+	}
+
+	publisher, err := identity.Generate()
+	if err != nil {
+		return err
+	}
+	if err := publisher.SaveToFile(*output); err != nil {
+		return err
+	}
+
+	log.Printf("Chave privada: %s", *output)
+	log.Printf("Chave pública: %s", identity.EncodePublicKey(publisher.PublicKey))
+	return nil
+}
+
+// runGenerate gera metadados de um arquivo ou de uma árvore de diretórios
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	path := fs.String("path", "", "Arquivo ou diretório a ser compartilhado (\"-\" lê da entrada padrão)")
+	name := fs.String("name", "", "Nome gravado nos metadados ao ler da entrada padrão")
+	blockSize := fs.Int("block-size", 1024, "Tamanho do bloco em bytes")
+	merkleMode := fs.Bool("merkle", false, "Gera metadados com raiz de Merkle em vez da lista de blocos")
+	cdc := fs.Bool("cdc", false, "Blocos de tamanho variável definidos pelo conteúdo (FastCDC); -block-size passa a ser o tamanho médio")
+	output := fs.String("output", "", "Arquivo de metadados (padrão: <path>.meta.json ou .meta.bin)")
+	format := fs.String("format", formatJSON, "Formato dos metadados: json ou binary")
+	signKey := fs.String("sign-key", "", "Chave Ed25519 do publicador para assinar os metadados (opcional)")
+	live := fs.Bool("live", false, "Conteúdo ao vivo, ainda sendo escrito; requer -sign-key (ver extend)")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "Goroutines de cálculo dos hashes dos blocos")
+	progress := fs.Bool("progress", true, "Mostra o progresso da geração em stderr")
+	hashName := fs.String("hash", checksum.DefaultAlgorithm, "Algoritmo de hash ("+strings.Join(checksum.Algorithms(), ", ")+")")
+	var peers stringList
+	fs.Var(&peers, "peer", "Peer (host:porta) incluído na URI; pode ser repetido")
+	fs.Parse(args)
+
+	if *path == "" {
+		return errors.New("-path é obrigatório")
+	}
+	if *blockSize <= 0 {
+		return errors.New("-block-size deve ser positivo")
+	}
+	if *path == "-" && (*name == "" || *output == "") {
+		return errors.New("-name e -output são obrigatórios ao ler da entrada padrão")
+	}
+	if *live && (*signKey == "" || *merkleMode || *cdc || *path == "-") {
+		return errors.New("-live requer -sign-key e não combina com -merkle, -cdc ou entrada padrão")
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filepath.Clean(*path) + defaultExtension(*format)
+	}
+
+	var publisher *identity.Identity
+	if *signKey != "" {
+		var err error
+		publisher, err = identity.LoadFromFile(*signKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	algorithm, err := checksum.Lookup(*hashName)
+	if err != nil {
+		return err
+	}
+	if !algorithm.Cryptographic {
+		log.Printf("Aviso: %s só detecta erros acidentais; use-o apenas em redes confiáveis", algorithm.Name)
+	}
+
+	options := metadata.GenerateOptions{
+		BlockSize: *blockSize,
+		Merkle:    *merkleMode,
+		Hash:      algorithm.Name,
+		Workers:   *workers,
+	}
+	if *cdc {
+		params := chunker.DefaultParams(*blockSize)
+		options.Chunking = &params
+	}
+	if *progress {
+		options.Progress = progressPrinter()
+	}
+
+	start := time.Now()
+	var meta *metadata.Metadata
+	if *path == "-" {
+		meta, err = metadata.GenerateFromReader(os.Stdin, *name, options)
+	} else if *live {
+		meta, err = metadata.GenerateLive(*path, options, publisher, false)
+	} else {
+		meta, err = metadata.Generate(*path, options)
+	}
+	if *progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Gerado em %v", time.Since(start).Round(time.Millisecond))
+
+	if publisher != nil && !meta.IsLive() {
+		if err := meta.Sign(publisher); err != nil {
+			return err
+		}
+	}
+
+	if err := saveMetadata(meta, *output, *format); err != nil {
+		return err
+	}
+
+	log.Printf("Metadados gerados: %s", *output)
+	if meta.IsMultiFile() {
+		log.Printf("Arquivos: %d (%d bytes, %d blocos)", len(meta.Files), meta.FileSize, meta.TotalBlocks)
+	} else {
+		log.Printf("Tamanho: %d bytes (%d blocos)", meta.FileSize, meta.TotalBlocks)
+	}
+	if meta.IsChunked() {
+		log.Printf("Chunking: %s (blocos de %d a %d bytes, média %d)", meta.Chunking.Algorithm, meta.Chunking.MinSize, meta.Chunking.MaxSize, meta.Chunking.AvgSize)
+	}
+	if meta.IsMerkle() {
+		log.Printf("Raiz de Merkle: %s", meta.Merkle.Root)
+	}
+	if meta.Signature != nil {
+		log.Printf("Assinado por %s", meta.Signature.PublicKey)
+	}
+	if meta.IsLive() {
+		log.Printf("Conteúdo ao vivo: publique os blocos novos com 'metatool extend'")
+	}
+
+	link, err := shareLink(meta, peers)
+	if err != nil {
+		return err
+	}
+	log.Printf("Info hash: %s", link.InfoHash)
+	log.Printf("URI: %s", link)
+	return nil
+}
+
+// progressPrinter imprime o avanço da geração em stderr, no máximo uma vez
+// por ponto percentual (ou por MB, quando o total é desconhecido)
+func progressPrinter() func(done, total int64) {
+	last := int64(-1)
+	return func(done, total int64) {
+		if total <= 0 {
+			if mb := done >> 20; mb != last {
+				last = mb
+				fmt.Fprintf(os.Stderr, "\rProcessados: %d MB", mb)
+			}
+			return
+		}
+
+		if pct := done * 100 / total; pct != last {
+			last = pct
+			fmt.Fprintf(os.Stderr, "\rProcessados: %3d%% (%d/%d bytes)", pct, done, total)
+		}
+	}
+}
+
+// runSign assina um arquivo de metadados existente
+func runSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	keyPath := fs.String("key", "", "Chave Ed25519 do publicador")
+	output := fs.String("output", "", "Arquivo de saída (padrão: sobrescreve -metadata)")
+	fs.Parse(args)
+
+	if *metaPath == "" || *keyPath == "" {
+		return errors.New("-metadata e -key são obrigatórios")
+	}
+	if *output == "" {
+		*output = *metaPath
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := identity.LoadFromFile(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	if err := meta.Sign(publisher); err != nil {
+		return err
+	}
+
+	// Mantém o formato do arquivo original
+	format, err := fileFormat(*metaPath)
+	if err != nil {
+		return err
+	}
+	if err := saveMetadata(meta, *output, format); err != nil {
+		return err
+	}
+
+	log.Printf("Metadados assinados por %s: %s", meta.Signature.PublicKey, *output)
+	return nil
+}
+
+// runExtend publica extensões de metadados ao vivo enquanto o arquivo cresce:
+// uma vez ou, com -interval, periodicamente até a extensão final
+func runExtend(args []string) error {
+	fs := flag.NewFlagSet("extend", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados ao vivo")
+	path := fs.String("path", "", "Arquivo que está sendo escrito")
+	keyPath := fs.String("key", "", "Chave Ed25519 do publicador")
+	final := fs.Bool("final", false, "Encerra o conteúdo, publicando também o último bloco parcial")
+	interval := fs.Duration("interval", 0, "Repete a publicação a cada intervalo (0 = uma vez)")
+	output := fs.String("output", "", "Arquivo de saída (padrão: sobrescreve -metadata)")
+	fs.Parse(args)
+
+	if *metaPath == "" || *path == "" || *keyPath == "" {
+		return errors.New("-metadata, -path e -key são obrigatórios")
+	}
+	if *output == "" {
+		*output = *metaPath
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+	if !meta.IsLive() {
+		return errors.New("metadados não são ao vivo (gere-os com generate -live)")
+	}
+
+	publisher, err := identity.LoadFromFile(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	// Mantém o formato do arquivo original
+	format, err := fileFormat(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	for {
+		next, err := meta.Extend(*path, publisher, *final)
+		switch {
+		case errors.Is(err, metadata.ErrNoNewBlocks):
+			if *interval == 0 {
+				log.Printf("Nenhum bloco completo novo (%d blocos, %d bytes)", meta.TotalBlocks, meta.FileSize)
+				return nil
+			}
+
+		case err != nil:
+			return err
+
+		default:
+			if err := replaceMetadata(next, *output, format); err != nil {
+				return err
+			}
+			log.Printf("Extensão %d: +%d blocos (%d blocos, %d bytes)",
+				next.Live.Seq, next.TotalBlocks-meta.TotalBlocks, next.TotalBlocks, next.FileSize)
+			meta = next
+		}
+
+		if meta.Live.Final {
+			log.Printf("Conteúdo encerrado: %s", *output)
+			return nil
+		}
+		if *interval == 0 {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// runVerify verifica a assinatura contra as chaves confiáveis informadas
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	var trustedKeys stringList
+	fs.Var(&trustedKeys, "trusted", "Chave pública confiável (ed25519:<hex>); pode ser repetida")
+	fs.Parse(args)
+
+	if *metaPath == "" || len(trustedKeys) == 0 {
+		return errors.New("-metadata e ao menos um -trusted são obrigatórios")
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	trusted, err := metadata.ParseTrustedKeys(trustedKeys)
+	if err != nil {
+		return err
+	}
+
+	if err := meta.VerifySignature(trusted); err != nil {
+		return err
+	}
+
+	log.Printf("✓ Assinatura válida (%s)", meta.Signature.PublicKey)
+	return nil
+}
+
+// runValidate carrega (migrando, se preciso) e valida metadados
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	fs.Parse(args)
+
+	if *metaPath == "" {
+		return errors.New("-metadata é obrigatório")
+	}
+
+	// LoadFromFile já migra e valida
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("✓ Metadados válidos (versão %d, %d blocos, %d bytes)", meta.Version, meta.TotalBlocks, meta.FileSize)
+	return nil
+}
+
+// runConvert regrava metadados no outro formato (ou no informado em -format)
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	output := fs.String("output", "", "Arquivo de saída")
+	format := fs.String("format", "", "Formato de saída: json ou binary (padrão: o oposto da entrada)")
+	fs.Parse(args)
+
+	if *metaPath == "" || *output == "" {
+		return errors.New("-metadata e -output são obrigatórios")
+	}
+
+	input, err := fileFormat(*metaPath)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatBinary
+		if input == formatBinary {
+			*format = formatJSON
+		}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+	if err := saveMetadata(meta, *output, *format); err != nil {
+		return err
+	}
+
+	before, err := os.Stat(*metaPath)
+	if err != nil {
+		return err
+	}
+	after, err := os.Stat(*output)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s (%s, %d bytes) -> %s (%s, %d bytes)", *metaPath, input, before.Size(), *output, *format, after.Size())
+	return nil
+}
+
+// runInfoHash imprime apenas o info hash, para uso em scripts
+func runInfoHash(args []string) error {
+	fs := flag.NewFlagSet("infohash", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	fs.Parse(args)
+
+	if *metaPath == "" {
+		return errors.New("-metadata é obrigatório")
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	infoHash, err := meta.InfoHash()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(infoHash)
+	return nil
+}
+
+// runURI imprime a URI de compartilhamento de metadados existentes
+func runURI(args []string) error {
+	fs := flag.NewFlagSet("uri", flag.ExitOnError)
+	metaPath := fs.String("metadata", "", "Arquivo de metadados")
+	var peers stringList
+	fs.Var(&peers, "peer", "Peer (host:porta) incluído na URI; pode ser repetido")
+	fs.Parse(args)
+
+	if *metaPath == "" {
+		return errors.New("-metadata é obrigatório")
+	}
+
+	meta, err := metadata.LoadFromFile(*metaPath)
+	if err != nil {
+		return err
+	}
+
+	link, err := shareLink(meta, peers)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(link)
+	return nil
+}
+
+// Formatos de arquivo de metadados
+const (
+	formatJSON   = "json"
+	formatBinary = "binary"
+)
+
+// checkFormat recusa formatos desconhecidos
+func checkFormat(format string) error {
+	if format != formatJSON && format != formatBinary {
+		return fmt.Errorf("formato desconhecido: %q (json ou binary)", format)
+	}
+	return nil
+}
+
+// defaultExtension retorna a extensão padrão do arquivo de metadados
+func defaultExtension(format string) string {
+	if format == formatBinary {
+		return ".meta.bin"
+	}
+	return ".meta.json"
+}
+
+// saveMetadata grava os metadados no formato pedido
+func saveMetadata(meta *metadata.Metadata, path, format string) error {
+	if format == formatBinary {
+		return meta.SaveBinaryToFile(path)
+	}
+	return meta.SaveToFile(path)
+}
+
+// replaceMetadata grava os metadados em um arquivo temporário e o renomeia,
+// de modo que um seeder que relê o arquivo nunca veja uma escrita pela metade
+func replaceMetadata(meta *metadata.Metadata, path, format string) error {
+	tmp := path + ".tmp"
+	if err := saveMetadata(meta, tmp, format); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("erro ao substituir %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileFormat detecta o formato de um arquivo de metadados existente
+func fileFormat(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if metadata.IsBinary(data) {
+		return formatBinary, nil
+	}
+	return formatJSON, nil
+}
+
+// shareLink monta o link p2psd dos metadados, validando os peers informados
+func shareLink(meta *metadata.Metadata, peers []string) (*magnet.Link, error) {
+	infoHash, err := meta.InfoHash()
+	if err != nil {
+		return nil, err
+	}
+
+	link := &magnet.Link{
+		InfoHash: infoHash,
+		Name:     meta.FileName,
+		Peers:    peers,
+	}
+
+	// Relê a URI para recusar peers mal formados
+	if _, err := magnet.Parse(link.String()); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// stringList permite flags repetidas
+type stringList []string
+
+func (l *stringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/zatta/tp2-p2p/internal/magnet"
 	"github.com/zatta/tp2-p2p/internal/peer"
 )
 
+// shutdownTimeout é o prazo para drenar conexões ao encerrar o peer
+const shutdownTimeout = 10 * time.Second
+
 // Config representa a configuração do peer carregada do JSON
 type Config struct {
 	PeerID       string          `json:"peer_id"`
@@ -19,9 +27,65 @@ type Config struct {
 	Mode         string          `json:"mode"`
 	FilePath     string          `json:"file_path"`
 	MetadataPath string          `json:"metadata_path"`
+	InfoHash     string          `json:"info_hash,omitempty"` // leecher obtém os metadados dos vizinhos
+	URI          string          `json:"uri,omitempty"`       // p2psd:?ih=...&peer=... (ver applyURI)
 	DownloadDir  string          `json:"download_dir"`
 	Neighbors    []NeighborEntry `json:"neighbors"`
 	LogFile      string          `json:"log_file,omitempty"`
+
+	// Versão anterior do conteúdo e seus metadados (leecher reaproveita os
+	// blocos inalterados)
+	BasePath         string `json:"base_path,omitempty"`
+	BaseMetadataPath string `json:"base_metadata_path,omitempty"`
+
+	// Limites do servidor (zero usa o padrão)
+	IdleTimeoutSeconds  int `json:"idle_timeout_seconds,omitempty"`
+	ReadTimeoutSeconds  int `json:"read_timeout_seconds,omitempty"`
+	WriteTimeoutSeconds int `json:"write_timeout_seconds,omitempty"`
+	MaxConnections      int `json:"max_connections,omitempty"`
+
+	// Regras de acesso por IP (recarregadas com SIGHUP)
+	AccessControl AccessControlEntry `json:"access_control,omitempty"`
+
+	// Chave Ed25519 do peer (criada se não existir); o ID passa a derivar dela
+	IdentityKey string `json:"identity_key,omitempty"`
+
+	// Chaves de publicadores aceitos para os metadados ("ed25519:<hex>")
+	TrustedPublishers []string `json:"trusted_publishers,omitempty"`
+
+	// Segredo compartilhado do swarm privado e cifragem opcional do stream
+	SwarmKey      string `json:"swarm_key,omitempty"`
+	EncryptStream bool   `json:"encrypt_stream,omitempty"`
+
+	// TLS com autenticação mútua (opcional)
+	TLS *TLSEntry `json:"tls,omitempty"`
+
+	// Conteúdos adicionais atendidos na mesma porta (daemon com vários
+	// conteúdos); o servidor escolhe o conteúdo pelo info hash pedido
+	Contents []ContentEntry `json:"contents,omitempty"`
+}
+
+// ContentEntry descreve um conteúdo adicional do daemon. Limites, regras de
+// acesso, identidade, chave do swarm e TLS são os da configuração principal.
+type ContentEntry struct {
+	Mode         string          `json:"mode"`
+	FilePath     string          `json:"file_path,omitempty"`
+	MetadataPath string          `json:"metadata_path,omitempty"`
+	InfoHash     string          `json:"info_hash,omitempty"`
+	Neighbors    []NeighborEntry `json:"neighbors,omitempty"`
+}
+
+// TLSEntry contém os caminhos de certificado, chave e bundle de CAs
+type TLSEntry struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	CAFile   string `json:"ca_file"`
+}
+
+// AccessControlEntry contém listas CIDR de permissão e bloqueio
+type AccessControlEntry struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
 }
 
 // NeighborEntry representa um vizinho na configuração
@@ -38,24 +102,26 @@ func main() {
 	mode := flag.String("mode", "", "Modo: seeder ou leecher")
 	filePath := flag.String("file", "", "Caminho do arquivo")
 	metadataPath := flag.String("metadata", "", "Caminho do arquivo de metadados")
+	infoHash := flag.String("info-hash", "", "Info hash do conteúdo (leecher obtém os metadados dos vizinhos)")
+	uri := flag.String("uri", "", "URI p2psd:?ih=...&peer=host:porta (define info hash e vizinhos)")
+	basePath := flag.String("base", "", "Versão anterior do conteúdo, da qual o leecher copia os blocos inalterados")
+	baseMetadataPath := flag.String("base-metadata", "", "Metadados da versão anterior (obrigatório com -base)")
 	downloadDir := flag.String("download-dir", "./downloads", "Diretório de download")
 	logFile := flag.String("log", "", "Arquivo de log (vazio = stdout)")
+	identityKey := flag.String("identity", "", "Arquivo da chave Ed25519 do peer (criado se não existir)")
+	maxConnections := flag.Int("max-connections", 0, "Máximo de conexões simultâneas no servidor")
 	flag.Parse()
 
 	var config Config
 
 	// Carrega configuração do arquivo JSON se fornecido
 	if *configFile != "" {
-		data, err := os.ReadFile(*configFile)
+		loaded, err := loadConfig(*configFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Erro ao ler arquivo de configuração: %v\n", err)
-			os.Exit(1)
-		}
-
-		if err := json.Unmarshal(data, &config); err != nil {
-			fmt.Fprintf(os.Stderr, "Erro ao parsear configuração JSON: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
 			os.Exit(1)
 		}
+		config = *loaded
 	}
 
 	// Flags sobrescrevem configuração do arquivo
@@ -74,16 +140,42 @@ func main() {
 	if *metadataPath != "" {
 		config.MetadataPath = *metadataPath
 	}
+	if *infoHash != "" {
+		config.InfoHash = *infoHash
+	}
+	if *basePath != "" {
+		config.BasePath = *basePath
+	}
+	if *baseMetadataPath != "" {
+		config.BaseMetadataPath = *baseMetadataPath
+	}
 	if *downloadDir != "" {
 		config.DownloadDir = *downloadDir
 	}
 	if *logFile != "" {
 		config.LogFile = *logFile
 	}
+	if *identityKey != "" {
+		config.IdentityKey = *identityKey
+	}
+	if *maxConnections != 0 {
+		config.MaxConnections = *maxConnections
+	}
+	if *uri != "" {
+		config.URI = *uri
+	}
+
+	// URI completa info hash, vizinhos e modo
+	if config.URI != "" {
+		if err := applyURI(&config); err != nil {
+			fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Valida configuração obrigatória
-	if config.PeerID == "" {
-		fmt.Fprintln(os.Stderr, "Erro: peer_id é obrigatório")
+	if config.PeerID == "" && config.IdentityKey == "" {
+		fmt.Fprintln(os.Stderr, "Erro: peer_id (ou identity_key) é obrigatório")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -97,8 +189,19 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	if config.MetadataPath == "" {
-		fmt.Fprintln(os.Stderr, "Erro: metadata_path é obrigatório")
+	if config.MetadataPath == "" && (config.InfoHash == "" || config.Mode != "leecher") {
+		fmt.Fprintln(os.Stderr, "Erro: metadata_path é obrigatório (leechers podem informar apenas info_hash)")
+		flag.Usage()
+		os.Exit(1)
+	}
+	for i, content := range config.Contents {
+		if content.MetadataPath == "" && (content.InfoHash == "" || content.Mode != "leecher") {
+			fmt.Fprintf(os.Stderr, "Erro: contents[%d]: metadata_path é obrigatório (leechers podem informar apenas info_hash)\n", i)
+			os.Exit(1)
+		}
+	}
+	if config.BasePath != "" && (config.BaseMetadataPath == "" || config.Mode != "leecher") {
+		fmt.Fprintln(os.Stderr, "Erro: base_path exige base_metadata_path e o modo leecher")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -119,39 +222,53 @@ func main() {
 		logger = log.New(os.Stdout, "", log.LstdFlags)
 	}
 
-	logger.Printf("=== Peer P2P: %s ===", config.PeerID)
-	logger.Printf("Modo: %s", config.Mode)
-	logger.Printf("Porta: %d", config.ListenPort)
-
 	// Converte modo
-	var peerMode peer.PeerMode
-	switch config.Mode {
-	case "seeder":
-		peerMode = peer.ModeSeeder
-	case "leecher":
-		peerMode = peer.ModeLeecher
-	default:
-		logger.Fatalf("Modo inválido: %s (use 'seeder' ou 'leecher')", config.Mode)
+	peerMode, err := parseMode(config.Mode)
+	if err != nil {
+		logger.Fatalf("%v", err)
 	}
 
-	// Converte vizinhos
-	neighbors := make([]peer.NeighborInfo, len(config.Neighbors))
-	for i, n := range config.Neighbors {
-		neighbors[i] = peer.NeighborInfo{
-			Address: fmt.Sprintf("%s:%d", n.IP, n.Port),
+	// Regras de acesso
+	accessList, err := peer.NewAccessList(config.AccessControl.Allow, config.AccessControl.Deny)
+	if err != nil {
+		logger.Fatalf("Erro nas regras de acesso: %v", err)
+	}
+
+	// TLS
+	var tlsOptions *peer.TLSOptions
+	if config.TLS != nil {
+		tlsOptions = &peer.TLSOptions{
+			CertFile: config.TLS.CertFile,
+			KeyFile:  config.TLS.KeyFile,
+			CAFile:   config.TLS.CAFile,
 		}
 	}
 
 	// Cria peer
 	peerConfig := peer.PeerConfig{
-		ID:           config.PeerID,
-		Mode:         peerMode,
-		Port:         config.ListenPort,
-		FilePath:     config.FilePath,
-		MetadataPath: config.MetadataPath,
-		DownloadDir:  config.DownloadDir,
-		Neighbors:    neighbors,
-		Logger:       logger,
+		ID:               config.PeerID,
+		Mode:             peerMode,
+		Port:             config.ListenPort,
+		FilePath:         config.FilePath,
+		MetadataPath:     config.MetadataPath,
+		InfoHash:         config.InfoHash,
+		BasePath:         config.BasePath,
+		BaseMetadataPath: config.BaseMetadataPath,
+		DownloadDir:      config.DownloadDir,
+		Neighbors:        convertNeighbors(config.Neighbors),
+		Logger:           logger,
+		Server: peer.ServerOptions{
+			IdleTimeout:    time.Duration(config.IdleTimeoutSeconds) * time.Second,
+			ReadTimeout:    time.Duration(config.ReadTimeoutSeconds) * time.Second,
+			WriteTimeout:   time.Duration(config.WriteTimeoutSeconds) * time.Second,
+			MaxConnections: config.MaxConnections,
+			AccessList:     accessList,
+		},
+		TLS:               tlsOptions,
+		IdentityPath:      config.IdentityKey,
+		TrustedPublishers: config.TrustedPublishers,
+		SwarmKey:          config.SwarmKey,
+		EncryptStream:     config.EncryptStream,
 	}
 
 	p, err := peer.NewPeer(peerConfig)
@@ -159,30 +276,206 @@ func main() {
 		logger.Fatalf("Erro ao criar peer: %v", err)
 	}
 
+	// ID só é conhecido aqui quando derivado da identity_key
+	logger.Printf("=== Peer P2P: %s ===", p.ID)
+	logger.Printf("Modo: %s", config.Mode)
+	logger.Printf("Porta: %d", config.ListenPort)
+
+	// Acompanha eventos do peer (assinado antes de Start para não perder nenhum)
+	p.SubscribeFunc(func(ev peer.Event) {
+		logEvent(ev, logger)
+	})
+
+	// Conteúdos adicionais, servidos pelo servidor do peer principal
+	contents := make([]*peer.Peer, 0, len(config.Contents))
+	for i, content := range config.Contents {
+		contentPeer, err := newContentPeer(peerConfig, content, p.Server)
+		if err != nil {
+			logger.Fatalf("Erro ao criar conteúdo %d: %v", i, err)
+		}
+		contentPeer.SubscribeFunc(func(ev peer.Event) {
+			logEvent(ev, logger)
+		})
+		contents = append(contents, contentPeer)
+	}
+
 	// Inicia peer
 	if err := p.Start(); err != nil {
 		logger.Fatalf("Erro ao iniciar peer: %v", err)
 	}
+	for i, contentPeer := range contents {
+		if err := contentPeer.Start(); err != nil {
+			logger.Fatalf("Erro ao iniciar conteúdo %d: %v", i, err)
+		}
+	}
+	if len(contents) > 0 {
+		logger.Printf("[PEER] Servindo %d conteúdos na porta %d", len(contents)+1, config.ListenPort)
+	}
 
-	// Captura sinais de interrupção
+	// Captura sinais de interrupção (SIGHUP recarrega as regras de acesso)
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Se for leecher, aguarda download em goroutine
-	if peerMode == peer.ModeLeecher {
-		go func() {
-			p.Wait()
-			logger.Printf("[PEER] Download completo. Peer continua operando como seeder.")
-			logger.Printf("[PEER] Pressione Ctrl+C para encerrar.")
-		}()
-	}
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Aguarda sinal de interrupção
-	<-sigChan
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadAccessList(*configFile, p, logger)
+	}
 	logger.Println("\n[PEER] Recebido sinal de interrupção. Encerrando...")
 
-	// Para peer
-	p.Stop()
+	// Para peer, aguardando transferências em andamento
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, contentPeer := range contents {
+		if err := contentPeer.Stop(ctx); err != nil {
+			logger.Printf("[PEER] Encerramento forçado do conteúdo %s: %v", contentPeer.InfoHash, err)
+		}
+	}
+	if err := p.Stop(ctx); err != nil {
+		logger.Printf("[PEER] Encerramento forçado: %v", err)
+	}
+
+	logger.Printf("[PEER] Peer %s encerrado", p.ID)
+}
+
+// parseMode converte o modo da configuração
+func parseMode(mode string) (peer.PeerMode, error) {
+	switch mode {
+	case "seeder":
+		return peer.ModeSeeder, nil
+	case "leecher":
+		return peer.ModeLeecher, nil
+	default:
+		return "", fmt.Errorf("modo inválido: %s (use 'seeder' ou 'leecher')", mode)
+	}
+}
 
-	logger.Printf("[PEER] Peer %s encerrado", config.PeerID)
+// convertNeighbors converte os vizinhos da configuração
+func convertNeighbors(entries []NeighborEntry) []peer.NeighborInfo {
+	neighbors := make([]peer.NeighborInfo, len(entries))
+	for i, n := range entries {
+		neighbors[i] = peer.NeighborInfo{
+			Address: fmt.Sprintf("%s:%d", n.IP, n.Port),
+		}
+	}
+	return neighbors
+}
+
+// newContentPeer cria o peer de um conteúdo adicional do daemon a partir da
+// configuração principal, registrando-o no servidor compartilhado
+func newContentPeer(base peer.PeerConfig, content ContentEntry, server *peer.Server) (*peer.Peer, error) {
+	mode, err := parseMode(content.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := base
+	cfg.Mode = mode
+	cfg.FilePath = content.FilePath
+	cfg.MetadataPath = content.MetadataPath
+	cfg.InfoHash = content.InfoHash
+	cfg.Neighbors = convertNeighbors(content.Neighbors)
+	cfg.BasePath = ""
+	cfg.BaseMetadataPath = ""
+	cfg.SharedServer = server
+
+	return peer.NewPeer(cfg)
+}
+
+// logEvent registra no log os eventos relevantes para quem opera o peer
+func logEvent(ev peer.Event, logger *log.Logger) {
+	switch ev.Type {
+	case peer.EventDownloadComplete:
+		logger.Printf("[PEER] Download completo. Peer continua operando como seeder.")
+		logger.Printf("[PEER] Pressione Ctrl+C para encerrar.")
+
+	case peer.EventValidationFailed:
+		logger.Printf("[PEER] Download concluído, mas o arquivo é inválido: %v", ev.Err)
+	}
+}
+
+// applyURI preenche a configuração a partir de config.URI: o info hash (que
+// precisa coincidir com info_hash, se informado), os vizinhos que ainda não
+// estão na lista e o modo leecher, se nenhum foi definido
+func applyURI(config *Config) error {
+	link, err := magnet.Parse(config.URI)
+	if err != nil {
+		return err
+	}
+
+	if config.InfoHash != "" && config.InfoHash != link.InfoHash {
+		return fmt.Errorf("info_hash %s difere do info hash da URI %s", config.InfoHash, link.InfoHash)
+	}
+	config.InfoHash = link.InfoHash
+
+	if config.Mode == "" {
+		config.Mode = "leecher"
+	}
+
+	known := make(map[string]bool)
+	for _, n := range config.Neighbors {
+		known[net.JoinHostPort(n.IP, strconv.Itoa(n.Port))] = true
+	}
+	for _, address := range link.Peers {
+		// Endereços já validados por magnet.Parse
+		host, portStr, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portStr)
+
+		key := net.JoinHostPort(host, portStr)
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		config.Neighbors = append(config.Neighbors, NeighborEntry{IP: host, Port: port})
+	}
+
+	if len(link.Trackers) > 0 {
+		fmt.Fprintf(os.Stderr, "Aviso: trackers da URI ignorados (descoberta não suportada): %v\n", link.Trackers)
+	}
+
+	return nil
+}
+
+// loadConfig lê a configuração do peer de um arquivo JSON
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler arquivo de configuração: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("erro ao parsear configuração JSON: %w", err)
+	}
+
+	return &config, nil
+}
+
+// reloadAccessList relê as regras de acesso do arquivo de configuração
+func reloadAccessList(configFile string, p *peer.Peer, logger *log.Logger) {
+	if configFile == "" {
+		logger.Printf("[PEER] SIGHUP ignorado: peer iniciado sem arquivo de configuração")
+		return
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		logger.Printf("[PEER] Falha ao recarregar regras de acesso: %v", err)
+		return
+	}
+
+	accessList, err := peer.NewAccessList(config.AccessControl.Allow, config.AccessControl.Deny)
+	if err != nil {
+		logger.Printf("[PEER] Falha ao recarregar regras de acesso: %v", err)
+		return
+	}
+
+	if err := p.Server.SetAccessList(accessList); err != nil {
+		logger.Printf("[PEER] Falha ao recarregar regras de acesso: %v", err)
+		return
+	}
+	logger.Printf("[PEER] Regras de acesso recarregadas (%d conexões negadas até agora)",
+		p.Server.RejectedConnections())
 }
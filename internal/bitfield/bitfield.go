@@ -0,0 +1,187 @@
+package bitfield
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Bitfield é um conjunto compacto de bits, um por bloco, armazenado em
+// palavras de 64 bits para contagem e busca rápidas
+type Bitfield struct {
+	words  []uint64
+	length int
+}
+
+// New cria um bitfield com n bits, todos zerados
+func New(n int) *Bitfield {
+	return &Bitfield{
+		words:  make([]uint64, (n+63)/64),
+		length: n,
+	}
+}
+
+// FromBytes reconstrói um bitfield a partir do formato de rede (ver Bytes)
+func FromBytes(data []byte, n int) (*Bitfield, error) {
+	if len(data) != (n+7)/8 {
+		return nil, fmt.Errorf("bitfield com tamanho inválido: %d bytes para %d bits", len(data), n)
+	}
+
+	b := New(n)
+	for i, octet := range data {
+		// Bit mais significativo do byte corresponde ao menor índice
+		octet = bits.Reverse8(octet)
+		b.words[i/8] |= uint64(octet) << (8 * (i % 8))
+	}
+
+	// Bits de preenchimento após o último bloco devem ser zero
+	if n%64 != 0 && len(b.words) > 0 && b.words[len(b.words)-1]>>(n%64) != 0 {
+		return nil, fmt.Errorf("bitfield com bits além do bloco %d", n-1)
+	}
+
+	return b, nil
+}
+
+// Len retorna o número de bits
+func (b *Bitfield) Len() int {
+	return b.length
+}
+
+// Grow aumenta o bitfield para n bits; os bits novos começam desligados
+func (b *Bitfield) Grow(n int) {
+	if n <= b.length {
+		return
+	}
+	for len(b.words) < (n+63)/64 {
+		b.words = append(b.words, 0)
+	}
+	b.length = n
+}
+
+// Set liga o bit i
+func (b *Bitfield) Set(i int) {
+	b.words[i/64] |= 1 << (i % 64)
+}
+
+// Clear desliga o bit i
+func (b *Bitfield) Clear(i int) {
+	b.words[i/64] &^= 1 << (i % 64)
+}
+
+// Test verifica se o bit i está ligado (índices fora do intervalo retornam false)
+func (b *Bitfield) Test(i int) bool {
+	if i < 0 || i >= b.length {
+		return false
+	}
+	return b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// SetAll liga todos os bits
+func (b *Bitfield) SetAll() {
+	for i := range b.words {
+		b.words[i] = ^uint64(0)
+	}
+	b.clearPadding()
+}
+
+// Count retorna o número de bits ligados
+func (b *Bitfield) Count() int {
+	count := 0
+	for _, w := range b.words {
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// NextSet retorna o menor índice >= from com bit ligado, ou -1
+func (b *Bitfield) NextSet(from int) int {
+	return b.next(from, false)
+}
+
+// NextClear retorna o menor índice >= from com bit desligado, ou -1
+func (b *Bitfield) NextClear(from int) int {
+	return b.next(from, true)
+}
+
+// NextClearIn retorna o menor índice >= from cujo bit está desligado aqui e
+// ligado em mask (ex: bloco que falta localmente e o vizinho possui), ou -1
+func (b *Bitfield) NextClearIn(from int, mask *Bitfield) int {
+	return b.scan(from, func(i int) uint64 {
+		if i >= len(mask.words) {
+			return 0
+		}
+		return ^b.words[i] & mask.words[i]
+	})
+}
+
+// next busca palavra a palavra, invertendo-as quando procura bits desligados
+func (b *Bitfield) next(from int, clear bool) int {
+	return b.scan(from, func(i int) uint64 {
+		if clear {
+			return ^b.words[i]
+		}
+		return b.words[i]
+	})
+}
+
+// scan retorna o menor índice >= from com bit ligado na palavra produzida por wordAt
+func (b *Bitfield) scan(from int, wordAt func(i int) uint64) int {
+	if from < 0 {
+		from = 0
+	}
+	if from >= b.length {
+		return -1
+	}
+
+	wordIdx := from / 64
+	word := wordAt(wordIdx) & (^uint64(0) << (from % 64))
+
+	for {
+		if word != 0 {
+			i := wordIdx*64 + bits.TrailingZeros64(word)
+			if i >= b.length {
+				return -1
+			}
+			return i
+		}
+
+		wordIdx++
+		if wordIdx >= len(b.words) {
+			return -1
+		}
+		word = wordAt(wordIdx)
+	}
+}
+
+// Indices retorna os índices dos bits ligados em ordem crescente
+func (b *Bitfield) Indices() []int {
+	indices := make([]int, 0, b.Count())
+	for i := b.NextSet(0); i != -1; i = b.NextSet(i + 1) {
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// Bytes serializa o bitfield para a rede: um bit por bloco, o bit mais
+// significativo do primeiro byte corresponde ao bloco 0
+func (b *Bitfield) Bytes() []byte {
+	data := make([]byte, (b.length+7)/8)
+	for i := range data {
+		octet := byte(b.words[i/8] >> (8 * (i % 8)))
+		data[i] = bits.Reverse8(octet)
+	}
+	return data
+}
+
+// Clone retorna uma cópia independente
+func (b *Bitfield) Clone() *Bitfield {
+	words := make([]uint64, len(b.words))
+	copy(words, b.words)
+	return &Bitfield{words: words, length: b.length}
+}
+
+// clearPadding zera os bits da última palavra que não correspondem a blocos
+func (b *Bitfield) clearPadding() {
+	if b.length%64 != 0 && len(b.words) > 0 {
+		b.words[len(b.words)-1] &= (1 << (b.length % 64)) - 1
+	}
+}
@@ -0,0 +1,106 @@
+package bitfield
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBytesRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 8, 63, 64, 65, 1000} {
+		b := New(n)
+		for i := 0; i < n; i += 3 {
+			b.Set(i)
+		}
+
+		decoded, err := FromBytes(b.Bytes(), n)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), b.Bytes()) || decoded.Count() != b.Count() {
+			t.Fatalf("n=%d: bitfield decodificado difere do original", n)
+		}
+	}
+}
+
+func TestBytesLayout(t *testing.T) {
+	b := New(10)
+	b.Set(0)
+	b.Set(9)
+
+	// Bloco 0 no bit mais significativo do primeiro byte
+	if got := b.Bytes(); !bytes.Equal(got, []byte{0x80, 0x40}) {
+		t.Fatalf("Bytes() = %x, esperado 8040", got)
+	}
+}
+
+func TestFromBytesRejectsPadding(t *testing.T) {
+	if _, err := FromBytes([]byte{0xff, 0xff}, 10); err == nil {
+		t.Fatal("bits além do último bloco foram aceitos")
+	}
+	if _, err := FromBytes([]byte{0xff}, 10); err == nil {
+		t.Fatal("tamanho inválido foi aceito")
+	}
+}
+
+func TestNextClearIn(t *testing.T) {
+	local := New(200)
+	remote := New(200)
+	for i := 0; i < 150; i++ {
+		local.Set(i)
+	}
+	remote.Set(10)
+	remote.Set(170)
+
+	if got := local.NextClearIn(0, remote); got != 170 {
+		t.Fatalf("NextClearIn = %d, esperado 170", got)
+	}
+	if got := local.NextClearIn(171, remote); got != -1 {
+		t.Fatalf("NextClearIn após o último = %d, esperado -1", got)
+	}
+}
+
+func TestGrowKeepsBits(t *testing.T) {
+	b := New(70)
+	b.SetAll()
+	b.Grow(200)
+
+	if b.Count() != 70 || b.Test(70) || b.NextClear(0) != 70 {
+		t.Fatalf("Grow alterou os bits existentes ou ligou bits novos (count=%d)", b.Count())
+	}
+}
+
+// BenchmarkCount compara a contagem por popcount com a contagem bloco a
+// bloco em um map[blockID]bool, como no gerenciador anterior
+func BenchmarkCount(b *testing.B) {
+	const n = 1 << 16
+
+	b.Run("popcount", func(b *testing.B) {
+		bf := New(n)
+		for i := 0; i < n; i += 2 {
+			bf.Set(i)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bf.Count()
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		available := make(map[int]bool, n/2)
+		for i := 0; i < n; i += 2 {
+			available[i] = true
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			count := 0
+			for blockID := 0; blockID < n; blockID++ {
+				if available[blockID] {
+					count++
+				}
+			}
+			_ = count
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package bitfield
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EncodeRuns codifica o bitfield como comprimentos de sequências alternadas
+// (uvarints), começando por uma sequência de bits desligados (possivelmente
+// vazia). Arquivos quase completos ou quase vazios viram poucos bytes.
+func (b *Bitfield) EncodeRuns() []byte {
+	var data []byte
+	var buf [binary.MaxVarintLen64]byte
+
+	pos := 0
+	set := false
+	for pos < b.length {
+		var end int
+		if set {
+			end = b.NextClear(pos)
+		} else {
+			end = b.NextSet(pos)
+		}
+		if end == -1 {
+			end = b.length
+		}
+
+		n := binary.PutUvarint(buf[:], uint64(end-pos))
+		data = append(data, buf[:n]...)
+
+		pos = end
+		set = !set
+	}
+
+	return data
+}
+
+// DecodeRuns reconstrói um bitfield de n bits codificado por EncodeRuns
+func DecodeRuns(data []byte, n int) (*Bitfield, error) {
+	b := New(n)
+
+	pos := 0
+	set := false
+	for len(data) > 0 {
+		run, size := binary.Uvarint(data)
+		if size <= 0 {
+			return nil, fmt.Errorf("sequência RLE inválida")
+		}
+		data = data[size:]
+
+		if run > uint64(n-pos) {
+			return nil, fmt.Errorf("sequência RLE excede %d bits", n)
+		}
+
+		if set {
+			for i := pos; i < pos+int(run); i++ {
+				b.Set(i)
+			}
+		}
+
+		pos += int(run)
+		set = !set
+	}
+
+	if pos != n {
+		return nil, fmt.Errorf("sequência RLE cobre %d de %d bits", pos, n)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,60 @@
+package bitfield
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunsRoundTrip(t *testing.T) {
+	patterns := map[string]func(i int) bool{
+		"vazio":     func(i int) bool { return false },
+		"completo":  func(i int) bool { return true },
+		"alternado": func(i int) bool { return i%2 == 1 },
+		"prefixo":   func(i int) bool { return i < 100 },
+		"lacunas":   func(i int) bool { return i%97 != 0 },
+	}
+
+	for name, isSet := range patterns {
+		for _, n := range []int{0, 1, 64, 1000} {
+			b := New(n)
+			for i := 0; i < n; i++ {
+				if isSet(i) {
+					b.Set(i)
+				}
+			}
+
+			decoded, err := DecodeRuns(b.EncodeRuns(), n)
+			if err != nil {
+				t.Fatalf("%s, n=%d: %v", name, n, err)
+			}
+			if !bytes.Equal(decoded.Bytes(), b.Bytes()) {
+				t.Fatalf("%s, n=%d: bitfield decodificado difere do original", name, n)
+			}
+		}
+	}
+}
+
+func TestRunsCompactForNearlyComplete(t *testing.T) {
+	b := New(1 << 20)
+	b.SetAll()
+	b.Clear(12345)
+
+	// Quatro sequências: nenhum desligado, 12345 ligados, um desligado, o resto ligado
+	if size := len(b.EncodeRuns()); size > 16 {
+		t.Fatalf("RLE de arquivo quase completo ocupa %d bytes", size)
+	}
+}
+
+func TestDecodeRunsRejectsInvalid(t *testing.T) {
+	cases := map[string][]byte{
+		"varint truncado": {0x80},
+		"excede o total":  {0x05, 0x06},
+		"não cobre tudo":  {0x02, 0x03},
+	}
+
+	for name, data := range cases {
+		if _, err := DecodeRuns(data, 10); err == nil {
+			t.Errorf("%s: sequência inválida aceita", name)
+		}
+	}
+}
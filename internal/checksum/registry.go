@@ -0,0 +1,122 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultAlgorithm é o algoritmo usado quando nenhum é escolhido
+const DefaultAlgorithm = "sha256"
+
+// Algorithm descreve um algoritmo de hash. Os hashes são gravados como
+// "<Name>:<hex>", de modo que o prefixo identifica o algoritmo.
+type Algorithm struct {
+	Name string // prefixo dos hashes
+	Size int    // tamanho do hash em bytes
+
+	// Cryptographic indica resistência a colisões deliberadas; algoritmos
+	// não criptográficos só detectam erros acidentais (redes confiáveis)
+	Cryptographic bool
+
+	New func() hash.Hash
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Algorithm)
+)
+
+func init() {
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+
+	Register(&Algorithm{Name: "sha256", Size: sha256.Size, Cryptographic: true, New: sha256.New})
+	Register(&Algorithm{Name: "sha512", Size: sha512.Size, Cryptographic: true, New: sha512.New})
+	Register(&Algorithm{Name: "crc32c", Size: crc32.Size, New: func() hash.Hash {
+		return crc32.New(castagnoli)
+	}})
+}
+
+// Register adiciona (ou substitui) um algoritmo no registro
+func Register(a *Algorithm) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[a.Name] = a
+}
+
+// Lookup retorna o algoritmo registrado com o nome informado
+func Lookup(name string) (*Algorithm, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	a, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("algoritmo de hash desconhecido: %q", name)
+	}
+	return a, nil
+}
+
+// Algorithms retorna os nomes dos algoritmos registrados, em ordem alfabética
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseHash separa um hash "<algoritmo>:<hex>", conferindo o algoritmo e o tamanho
+func ParseHash(value string) (*Algorithm, []byte, error) {
+	name, encoded, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("hash sem prefixo de algoritmo: %q", value)
+	}
+
+	a, err := Lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := hex.DecodeString(encoded)
+	if err != nil || len(raw) != a.Size {
+		return nil, nil, fmt.Errorf("hash %s mal formado: %q", name, value)
+	}
+
+	return a, raw, nil
+}
+
+// Sum calcula o hash formatado dos dados
+func (a *Algorithm) Sum(data []byte) string {
+	h := a.New()
+	h.Write(data)
+	return a.Format(h)
+}
+
+// SumReader calcula o hash formatado de todo o conteúdo de r e retorna
+// também o número de bytes lidos
+func (a *Algorithm) SumReader(r io.Reader) (string, int64, error) {
+	h := a.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, fmt.Errorf("erro ao calcular hash: %w", err)
+	}
+
+	return a.Format(h), n, nil
+}
+
+// Format gera o texto "<Name>:<hex>" de um hash criado por New
+func (a *Algorithm) Format(h hash.Hash) string {
+	return a.Name + ":" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,150 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Algorithm é o nome gravado nos metadados para este chunker
+const Algorithm = "fastcdc"
+
+// gear é a tabela do hash rolante. Os valores fazem parte do formato: mudar a
+// tabela muda os cortes e, portanto, os blocos de todos os metadados.
+var gear [256]uint64
+
+func init() {
+	// splitmix64 com semente fixa
+	state := uint64(0x70327073642d6364) // "p2psd-cd"
+	for i := range gear {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Params são os limites de tamanho dos blocos
+type Params struct {
+	Min int // nenhum corte antes de Min bytes (exceto no fim do conteúdo)
+	Avg int // tamanho médio desejado
+	Max int // corte forçado em Max bytes
+}
+
+// DefaultParams deriva os limites de um tamanho médio: Min = Avg/4, Max = Avg*4
+func DefaultParams(avg int) Params {
+	return Params{Min: max(avg/4, 1), Avg: avg, Max: avg * 4}
+}
+
+// Validate confere se os limites são utilizáveis
+func (p Params) Validate() error {
+	if p.Min <= 0 || p.Min > p.Avg || p.Avg > p.Max {
+		return fmt.Errorf("limites de chunking inválidos: min=%d avg=%d max=%d (esperado 0 < min <= avg <= max)", p.Min, p.Avg, p.Max)
+	}
+	return nil
+}
+
+// Chunker divide um stream em blocos de tamanho variável definidos pelo
+// conteúdo (FastCDC): inserir ou remover bytes só altera os blocos vizinhos à
+// mudança, e os demais mantêm o mesmo hash.
+type Chunker struct {
+	r      io.Reader
+	params Params
+	maskS  uint64 // máscara mais exigente, usada antes de Avg
+	maskL  uint64 // máscara mais permissiva, usada depois de Avg
+
+	buf []byte
+	eof bool
+}
+
+// New cria um chunker sobre r
+func New(r io.Reader, params Params) (*Chunker, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Normalização nível 2: dois bits a mais (ou a menos) que log2(Avg)
+	n := bits.Len(uint(params.Avg)) - 1
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  topBits(n + 2),
+		maskL:  topBits(max(n-2, 1)),
+		buf:    make([]byte, 0, params.Max),
+	}, nil
+}
+
+// Next retorna o próximo bloco, ou io.EOF ao fim do stream. O slice
+// retornado pertence ao chamador.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf)
+	chunk := make([]byte, n)
+	copy(chunk, c.buf[:n])
+
+	// Mantém o restante no início do buffer
+	c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+
+	return chunk, nil
+}
+
+// fill completa o buffer até Max bytes ou o fim do stream
+func (c *Chunker) fill() error {
+	if c.eof || len(c.buf) == cap(c.buf) {
+		return nil
+	}
+
+	n, err := io.ReadFull(c.r, c.buf[len(c.buf):cap(c.buf)])
+	c.buf = c.buf[:len(c.buf)+n]
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		c.eof = true
+		return nil
+	default:
+		return err
+	}
+}
+
+// cut encontra o tamanho do próximo bloco no início de data
+func (c *Chunker) cut(data []byte) int {
+	n := min(len(data), c.params.Max)
+	if n <= c.params.Min {
+		return n
+	}
+	normal := min(n, c.params.Avg)
+
+	var h uint64
+	i := c.params.Min
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// topBits retorna uma máscara com os n bits mais significativos, que no gear
+// hash dependem dos últimos 64 bytes lidos
+func topBits(n int) uint64 {
+	n = min(n, 64)
+	return ^uint64(0) << (64 - n)
+}
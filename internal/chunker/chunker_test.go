@@ -0,0 +1,121 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testContent gera conteúdo pseudoaleatório reprodutível
+func testContent(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// split divide data em blocos com os parâmetros informados
+func split(t *testing.T, data []byte, params Params) [][]byte {
+	t.Helper()
+
+	c, err := New(bytes.NewReader(data), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// chunkHashes indexa os blocos pelo hash
+func chunkHashes(chunks [][]byte) map[[sha256.Size]byte]bool {
+	hashes := make(map[[sha256.Size]byte]bool, len(chunks))
+	for _, chunk := range chunks {
+		hashes[sha256.Sum256(chunk)] = true
+	}
+	return hashes
+}
+
+func TestChunksCoverContentWithinLimits(t *testing.T) {
+	params := DefaultParams(4096)
+	data := testContent(1<<20, 1)
+
+	chunks := split(t, data, params)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("blocos concatenados diferem do conteúdo")
+	}
+
+	for i, chunk := range chunks {
+		last := i == len(chunks)-1
+		if len(chunk) > params.Max || (!last && len(chunk) < params.Min) {
+			t.Fatalf("bloco %d com %d bytes fora dos limites [%d, %d]", i, len(chunk), params.Min, params.Max)
+		}
+	}
+
+	// Tamanho médio próximo do pedido (folga ampla: depende do conteúdo)
+	avg := len(data) / len(chunks)
+	if avg < params.Avg/2 || avg > params.Avg*2 {
+		t.Fatalf("tamanho médio %d distante de %d", avg, params.Avg)
+	}
+}
+
+func TestChunksAreDeterministic(t *testing.T) {
+	params := DefaultParams(4096)
+	data := testContent(256<<10, 2)
+
+	first := split(t, data, params)
+	second := split(t, data, params)
+	if len(first) != len(second) {
+		t.Fatalf("número de blocos variou: %d e %d", len(first), len(second))
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("bloco %d variou entre execuções", i)
+		}
+	}
+}
+
+func TestBoundariesSurviveInsertion(t *testing.T) {
+	params := DefaultParams(4096)
+	data := testContent(1<<20, 3)
+
+	// Insere bytes perto do início: com blocos fixos, todos os seguintes mudariam
+	edited := append(append(append([]byte(nil), data[:10000]...), []byte("inserção")...), data[10000:]...)
+
+	original := split(t, data, params)
+	changed := chunkHashes(split(t, edited, params))
+
+	kept := 0
+	for _, chunk := range original {
+		if changed[sha256.Sum256(chunk)] {
+			kept++
+		}
+	}
+
+	// Apenas os blocos vizinhos à inserção podem mudar
+	if lost := len(original) - kept; lost > 3 {
+		t.Fatalf("inserção alterou %d de %d blocos", lost, len(original))
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	for _, params := range []Params{
+		{Min: 0, Avg: 10, Max: 20},
+		{Min: 20, Avg: 10, Max: 40},
+		{Min: 5, Avg: 10, Max: 8},
+	} {
+		if _, err := New(bytes.NewReader(nil), params); err == nil {
+			t.Errorf("parâmetros inválidos aceitos: %+v", params)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// publicKeyPrefix identifica chaves públicas Ed25519 em formato texto
+const publicKeyPrefix = "ed25519:"
+
+// peerIDLength é o número de bytes do hash da chave pública usados no ID
+const peerIDLength = 16
+
+// Identity é o par de chaves Ed25519 que identifica um peer de forma persistente
+type Identity struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+// Generate cria uma nova identidade aleatória
+func Generate() (*Identity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gerar chave: %w", err)
+	}
+
+	return &Identity{PrivateKey: priv, PublicKey: pub}, nil
+}
+
+// LoadFromFile carrega uma identidade de um arquivo PEM (PKCS#8)
+func LoadFromFile(filePath string) (*Identity, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler chave: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("arquivo %s não contém uma chave privada PEM", filePath)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decodificar chave: %w", err)
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("chave em %s não é Ed25519", filePath)
+	}
+
+	return &Identity{PrivateKey: priv, PublicKey: priv.Public().(ed25519.PublicKey)}, nil
+}
+
+// LoadOrGenerate carrega a identidade do arquivo ou, se ele não existir, gera
+// uma nova e a salva. O segundo retorno indica se a chave foi criada agora.
+func LoadOrGenerate(filePath string) (*Identity, bool, error) {
+	id, err := LoadFromFile(filePath)
+	if err == nil {
+		return id, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	id, err = Generate()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := id.SaveToFile(filePath); err != nil {
+		return nil, false, err
+	}
+
+	return id, true, nil
+}
+
+// SaveToFile salva a chave privada em PEM (PKCS#8) com permissão restrita
+func (id *Identity) SaveToFile(filePath string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(id.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar chave: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("erro ao escrever chave: %w", err)
+	}
+
+	return nil
+}
+
+// PeerID retorna o ID do peer derivado da chave pública
+func (id *Identity) PeerID() string {
+	return PeerIDFromPublicKey(id.PublicKey)
+}
+
+// Sign assina uma mensagem com a chave privada
+func (id *Identity) Sign(message []byte) []byte {
+	return ed25519.Sign(id.PrivateKey, message)
+}
+
+// PeerIDFromPublicKey deriva o ID de um peer a partir de sua chave pública
+func PeerIDFromPublicKey(pub ed25519.PublicKey) string {
+	hash := sha256.Sum256(pub)
+	return hex.EncodeToString(hash[:peerIDLength])
+}
+
+// Verify verifica a assinatura de uma mensagem
+func Verify(pub ed25519.PublicKey, message, signature []byte) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(pub, message, signature)
+}
+
+// EncodePublicKey converte uma chave pública para texto ("ed25519:<hex>")
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return publicKeyPrefix + hex.EncodeToString(pub)
+}
+
+// ParsePublicKey converte o formato texto de volta para chave pública
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(s, publicKeyPrefix) {
+		return nil, fmt.Errorf("chave pública sem prefixo %q: %s", publicKeyPrefix, s)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(s, publicKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("chave pública inválida: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("chave pública com tamanho inválido: %d bytes", len(raw))
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
@@ -0,0 +1,101 @@
+package magnet
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+)
+
+// Scheme é o esquema das URIs de compartilhamento
+const Scheme = "p2psd"
+
+// Link reúne o necessário para entrar no swarm de um conteúdo:
+// p2psd:?ih=<info hash>&name=<nome>&peer=<host:porta>&tracker=<url>
+type Link struct {
+	InfoHash string   // ih: identificador do swarm (obrigatório)
+	Name     string   // name: nome sugerido, apenas informativo
+	Peers    []string // peer: vizinhos iniciais no formato host:porta
+	Trackers []string // tracker: serviços de descoberta
+}
+
+// Parse interpreta uma URI p2psd
+func Parse(uri string) (*Link, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("URI inválida: %w", err)
+	}
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("esquema %q não suportado (esperado %s:)", u.Scheme, Scheme)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("parâmetros da URI inválidos: %w", err)
+	}
+
+	hashes := query["ih"]
+	if len(hashes) != 1 {
+		return nil, fmt.Errorf("URI deve ter exatamente um parâmetro ih")
+	}
+	infoHash, err := metadata.ParseInfoHash(hashes[0])
+	if err != nil {
+		return nil, err
+	}
+
+	link := &Link{
+		InfoHash: infoHash,
+		Name:     query.Get("name"),
+		Trackers: query["tracker"],
+	}
+
+	for _, peer := range query["peer"] {
+		if err := checkPeerAddress(peer); err != nil {
+			return nil, err
+		}
+		link.Peers = append(link.Peers, peer)
+	}
+
+	return link, nil
+}
+
+// String monta a URI; os parâmetros seguem a ordem ih, name, peer, tracker
+func (l *Link) String() string {
+	var b strings.Builder
+	b.WriteString(Scheme + ":?ih=" + l.InfoHash)
+
+	if l.Name != "" {
+		b.WriteString("&name=" + escape(l.Name))
+	}
+	for _, peer := range l.Peers {
+		b.WriteString("&peer=" + escape(peer))
+	}
+	for _, tracker := range l.Trackers {
+		b.WriteString("&tracker=" + escape(tracker))
+	}
+
+	return b.String()
+}
+
+// checkPeerAddress verifica se o vizinho está no formato host:porta
+func checkPeerAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return fmt.Errorf("peer inválido: %q (esperado host:porta)", address)
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("porta inválida em %q", address)
+	}
+
+	return nil
+}
+
+// escape codifica um valor de parâmetro, mantendo ":" legível em host:porta
+func escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "%3A", ":")
+}
@@ -0,0 +1,206 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/zatta/tp2-p2p/internal/bitfield"
+)
+
+// HashSize é o tamanho de cada nó da árvore em bytes
+const HashSize = sha256.Size
+
+// Prefixos de domínio: impedem que um nó interno seja apresentado como folha
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Erros de verificação de prova
+var (
+	ErrInvalidProof = errors.New("prova de Merkle não confere com a raiz")
+	ErrUnknownNode  = errors.New("nó da árvore ainda não conhecido")
+)
+
+// Tree é uma árvore de Merkle binária completa sobre as folhas dos blocos,
+// completada até a próxima potência de dois com folhas zeradas. Os nós ficam
+// em layout de heap (raiz = 1, filhos de i = 2i e 2i+1). Uma árvore parcial
+// conhece apenas a raiz e os caminhos já verificados.
+type Tree struct {
+	leafCount int
+	width     int                // número de folhas, incluindo o preenchimento
+	nodes     []byte             // (2*width) nós de HashSize bytes; índice 0 não é usado
+	known     *bitfield.Bitfield // nós com valor conhecido
+}
+
+// LeafHash calcula a folha correspondente aos dados de um bloco
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash calcula um nó interno a partir dos filhos
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// newTree aloca uma árvore vazia com os nós de preenchimento já calculados
+func newTree(leafCount int) *Tree {
+	width := 1
+	for width < leafCount {
+		width *= 2
+	}
+
+	t := &Tree{
+		leafCount: leafCount,
+		width:     width,
+		nodes:     make([]byte, 2*width*HashSize),
+		known:     bitfield.New(2 * width),
+	}
+
+	// Folhas de preenchimento são zeradas; subárvores só de preenchimento
+	// são calculadas uma vez aqui
+	for i := width + leafCount; i < 2*width; i++ {
+		t.known.Set(i)
+	}
+	for i := width - 1; i >= 1; i-- {
+		if t.known.Test(2*i) && t.known.Test(2*i+1) {
+			t.setNode(i, nodeHash(t.node(2*i), t.node(2*i+1)))
+		}
+	}
+
+	return t
+}
+
+// Build monta a árvore completa a partir das folhas (ver LeafHash)
+func Build(leaves [][]byte) (*Tree, error) {
+	t := newTree(len(leaves))
+
+	for i, leaf := range leaves {
+		if len(leaf) != HashSize {
+			return nil, fmt.Errorf("folha %d com tamanho inválido: %d bytes", i, len(leaf))
+		}
+		t.setNode(t.width+i, leaf)
+	}
+
+	for i := t.width - 1; i >= 1; i-- {
+		if !t.known.Test(i) {
+			t.setNode(i, nodeHash(t.node(2*i), t.node(2*i+1)))
+		}
+	}
+
+	return t, nil
+}
+
+// NewPartial cria uma árvore que conhece apenas a raiz confiável; folhas são
+// aprendidas à medida que provas são verificadas (ver Verify)
+func NewPartial(leafCount int, root []byte) (*Tree, error) {
+	if len(root) != HashSize {
+		return nil, fmt.Errorf("raiz com tamanho inválido: %d bytes", len(root))
+	}
+
+	t := newTree(leafCount)
+	if t.known.Test(1) && !bytes.Equal(t.node(1), root) {
+		return nil, ErrInvalidProof
+	}
+	t.setNode(1, root)
+
+	return t, nil
+}
+
+// LeafCount retorna o número de folhas reais (blocos)
+func (t *Tree) LeafCount() int {
+	return t.leafCount
+}
+
+// Root retorna a raiz da árvore
+func (t *Tree) Root() []byte {
+	return append([]byte(nil), t.node(1)...)
+}
+
+// Leaf retorna a folha de um bloco, se já conhecida
+func (t *Tree) Leaf(index int) ([]byte, bool) {
+	if index < 0 || index >= t.leafCount || !t.known.Test(t.width+index) {
+		return nil, false
+	}
+	return append([]byte(nil), t.node(t.width+index)...), true
+}
+
+// Proof retorna os irmãos do caminho da folha até a raiz
+func (t *Tree) Proof(index int) ([][]byte, error) {
+	if index < 0 || index >= t.leafCount {
+		return nil, fmt.Errorf("folha %d fora do intervalo [0, %d)", index, t.leafCount)
+	}
+
+	var proof [][]byte
+	for i := t.width + index; i > 1; i /= 2 {
+		sibling := i ^ 1
+		if !t.known.Test(sibling) {
+			return nil, ErrUnknownNode
+		}
+		proof = append(proof, append([]byte(nil), t.node(sibling)...))
+	}
+
+	return proof, nil
+}
+
+// Verify confere se leaf está na posição index segundo a prova e a raiz da
+// árvore. Se conferir, a folha e os nós da prova passam a ser conhecidos,
+// permitindo que este peer gere a mesma prova para outros.
+func (t *Tree) Verify(index int, leaf []byte, proof [][]byte) error {
+	if index < 0 || index >= t.leafCount {
+		return fmt.Errorf("folha %d fora do intervalo [0, %d)", index, t.leafCount)
+	}
+	if len(leaf) != HashSize {
+		return fmt.Errorf("folha com tamanho inválido: %d bytes", len(leaf))
+	}
+
+	// Recalcula o caminho até a raiz
+	path := make([][]byte, 0, len(proof)+1)
+	current := leaf
+	i := t.width + index
+	for _, sibling := range proof {
+		if i <= 1 || len(sibling) != HashSize {
+			return ErrInvalidProof
+		}
+		path = append(path, current)
+		if i%2 == 0 {
+			current = nodeHash(current, sibling)
+		} else {
+			current = nodeHash(sibling, current)
+		}
+		i /= 2
+	}
+	if i != 1 || !bytes.Equal(current, t.node(1)) {
+		return ErrInvalidProof
+	}
+
+	// Memoriza o caminho verificado e os irmãos
+	i = t.width + index
+	for k, sibling := range proof {
+		t.setNode(i, path[k])
+		t.setNode(i^1, sibling)
+		i /= 2
+	}
+
+	return nil
+}
+
+// node retorna o valor do nó i (sem cópia)
+func (t *Tree) node(i int) []byte {
+	return t.nodes[i*HashSize : (i+1)*HashSize]
+}
+
+// setNode grava o valor do nó i e o marca como conhecido
+func (t *Tree) setNode(i int, value []byte) {
+	copy(t.node(i), value)
+	t.known.Set(i)
+}
@@ -0,0 +1,119 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testLeaves gera as folhas de n blocos distintos
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash([]byte(fmt.Sprintf("bloco %d", i)))
+	}
+	return leaves
+}
+
+func TestProofVerify(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8, 13} {
+		leaves := testLeaves(n)
+		full, err := Build(leaves)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+
+		partial, err := NewPartial(n, full.Root())
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+
+		for i, leaf := range leaves {
+			proof, err := full.Proof(i)
+			if err != nil {
+				t.Fatalf("n=%d, folha %d: %v", n, i, err)
+			}
+			if err := partial.Verify(i, leaf, proof); err != nil {
+				t.Fatalf("n=%d, folha %d: prova válida recusada: %v", n, i, err)
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	leaves := testLeaves(6)
+	full, err := Build(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := full.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamperedProof := make([][]byte, len(proof))
+	for i := range proof {
+		tamperedProof[i] = append([]byte(nil), proof[i]...)
+	}
+	tamperedProof[1][0] ^= 0xff
+
+	cases := []struct {
+		name  string
+		index int
+		leaf  []byte
+		proof [][]byte
+	}{
+		{"folha alterada", 2, LeafHash([]byte("outro conteúdo")), proof},
+		{"irmão alterado", 2, leaves[2], tamperedProof},
+		{"posição trocada", 3, leaves[2], proof},
+		{"prova curta", 2, leaves[2], proof[:len(proof)-1]},
+		{"prova longa", 2, leaves[2], append(append([][]byte(nil), proof...), proof[0])},
+		{"irmão com tamanho inválido", 2, leaves[2], [][]byte{proof[0][:10], proof[1], proof[2]}},
+	}
+
+	for _, tc := range cases {
+		partial, err := NewPartial(len(leaves), full.Root())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := partial.Verify(tc.index, tc.leaf, tc.proof); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("%s: Verify = %v, esperado ErrInvalidProof", tc.name, err)
+		}
+		if _, ok := partial.Leaf(tc.index); ok {
+			t.Errorf("%s: folha recusada ficou registrada na árvore", tc.name)
+		}
+	}
+}
+
+func TestPartialTreeServesVerifiedProofs(t *testing.T) {
+	leaves := testLeaves(5)
+	full, err := Build(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := NewPartial(len(leaves), full.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := partial.Proof(3); !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("prova de folha desconhecida: %v, esperado ErrUnknownNode", err)
+	}
+
+	proof, _ := full.Proof(3)
+	if err := partial.Verify(3, leaves[3], proof); err != nil {
+		t.Fatal(err)
+	}
+
+	// O leecher repassa a mesma prova que recebeu
+	relayed, err := partial.Proof(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range proof {
+		if !bytes.Equal(relayed[i], proof[i]) {
+			t.Fatalf("prova repassada difere no nível %d", i)
+		}
+	}
+}
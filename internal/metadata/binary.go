@@ -0,0 +1,393 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/identity"
+)
+
+// binaryMagic inicia os metadados no formato binário; JSON nunca começa assim
+var binaryMagic = []byte("P2PM\x01")
+
+// Seções opcionais presentes na codificação binária
+const (
+	sectionBlocks byte = 1 << iota
+	sectionFiles
+	sectionMerkle
+	sectionChunking
+	sectionSignature
+	sectionLive
+
+	knownSections = sectionBlocks | sectionFiles | sectionMerkle | sectionChunking | sectionSignature | sectionLive
+)
+
+// IsBinary verifica se data está no formato binário (ver EncodeBinary)
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, binaryMagic)
+}
+
+// EncodeBinary serializa os metadados no formato binário compacto: inteiros
+// em varint e hashes em bytes crus. Campos que a validação exige derivados
+// de outros (IDs e posições dos blocos, posições dos arquivos) não são
+// gravados. A decodificação reproduz exatamente os mesmos metadados, com o
+// mesmo info hash e a mesma assinatura.
+func (m *Metadata) EncodeBinary() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	algorithm, fileHash, err := parseCanonicalHash(m.FileHash)
+	if err != nil {
+		return nil, fmt.Errorf("file_hash: %w", err)
+	}
+
+	var sections byte
+	if len(m.Blocks) > 0 {
+		sections |= sectionBlocks
+	}
+	if m.IsMultiFile() {
+		sections |= sectionFiles
+	}
+	if m.IsMerkle() {
+		sections |= sectionMerkle
+	}
+	if m.IsChunked() {
+		sections |= sectionChunking
+	}
+	if m.Signature != nil {
+		sections |= sectionSignature
+	}
+	if m.IsLive() {
+		sections |= sectionLive
+	}
+
+	w := &binaryWriter{}
+	w.buf.Write(binaryMagic)
+	w.uint(uint64(m.Version))
+	w.string(m.FileName)
+	w.uint(uint64(m.FileSize))
+	w.uint(uint64(m.BlockSize))
+	w.uint(uint64(m.TotalBlocks))
+	w.string(algorithm.Name)
+	w.buf.Write(fileHash)
+	w.buf.WriteByte(sections)
+
+	if sections&sectionChunking != 0 {
+		w.string(m.Chunking.Algorithm)
+		w.uint(uint64(m.Chunking.MinSize))
+		w.uint(uint64(m.Chunking.AvgSize))
+		w.uint(uint64(m.Chunking.MaxSize))
+	}
+
+	if sections&sectionBlocks != 0 {
+		for i, block := range m.Blocks {
+			_, raw, err := parseCanonicalHash(block.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("blocks[%d].hash: %w", i, err)
+			}
+			// Sem chunking o tamanho decorre de block_size
+			if m.IsChunked() {
+				w.uint(uint64(block.Size))
+			}
+			w.buf.Write(raw)
+		}
+	}
+
+	if sections&sectionFiles != 0 {
+		w.uint(uint64(len(m.Files)))
+		for _, f := range m.Files {
+			w.string(f.Path)
+			w.uint(uint64(f.Size))
+			w.uint(uint64(f.Mode))
+		}
+	}
+
+	if sections&sectionMerkle != 0 {
+		root, err := m.MerkleRoot()
+		if err != nil {
+			return nil, err
+		}
+		if EncodeMerkleRoot(root) != m.Merkle.Root {
+			return nil, fmt.Errorf("merkle.root fora da forma canônica: %s", m.Merkle.Root)
+		}
+		w.string(m.Merkle.Leaf)
+		w.buf.Write(root)
+	}
+
+	if sections&sectionSignature != 0 {
+		pub, err := identity.ParsePublicKey(m.Signature.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("signature.public_key: %w", err)
+		}
+		if identity.EncodePublicKey(pub) != m.Signature.PublicKey {
+			return nil, fmt.Errorf("signature.public_key fora da forma canônica: %s", m.Signature.PublicKey)
+		}
+		w.buf.Write(pub)
+		w.bytes(m.Signature.Value)
+	}
+
+	if sections&sectionLive != 0 {
+		pub, err := identity.ParsePublicKey(m.Live.Publisher)
+		if err != nil {
+			return nil, fmt.Errorf("live.publisher: %w", err)
+		}
+		if identity.EncodePublicKey(pub) != m.Live.Publisher {
+			return nil, fmt.Errorf("live.publisher fora da forma canônica: %s", m.Live.Publisher)
+		}
+		w.buf.Write(pub)
+		w.uint(uint64(m.Live.Seq))
+		if m.Live.Final {
+			w.buf.WriteByte(1)
+		} else {
+			w.buf.WriteByte(0)
+		}
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+// SaveBinaryToFile salva os metadados no formato binário
+func (m *Metadata) SaveBinaryToFile(filePath string) error {
+	data, err := m.EncodeBinary()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("erro ao escrever arquivo: %w", err)
+	}
+
+	return nil
+}
+
+// decodeBinary reconstrói metadados gravados por EncodeBinary
+func decodeBinary(data []byte) (*Metadata, error) {
+	r := &binaryReader{data: data[len(binaryMagic):]}
+
+	m := &Metadata{
+		Version:     r.int(),
+		FileName:    r.string(),
+		FileSize:    int64(r.uint()),
+		BlockSize:   r.int(),
+		TotalBlocks: r.int(),
+	}
+
+	algorithm, err := checksum.Lookup(r.string())
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return nil, r.failure()
+	}
+	m.FileHash = formatHash(algorithm, r.next(algorithm.Size))
+	sections := r.byte()
+	if sections&^knownSections != 0 {
+		return nil, fmt.Errorf("%w: seções desconhecidas no formato binário: %#x", ErrInvalid, sections)
+	}
+
+	if sections&sectionChunking != 0 {
+		m.Chunking = &ChunkingInfo{
+			Algorithm: r.string(),
+			MinSize:   r.int(),
+			AvgSize:   r.int(),
+			MaxSize:   r.int(),
+		}
+	}
+
+	if sections&sectionBlocks != 0 {
+		// Limita a alocação pelo que os dados restantes podem conter
+		if m.TotalBlocks < 0 || m.TotalBlocks > len(r.data)/algorithm.Size {
+			return nil, fmt.Errorf("%w: total_blocks = %d excede os dados", ErrInvalid, m.TotalBlocks)
+		}
+
+		layout := m.Layout()
+		m.Blocks = make([]BlockInfo, m.TotalBlocks)
+		var offset int64
+		for i := range m.Blocks {
+			size := 0
+			if m.IsChunked() {
+				size = r.int()
+			} else if _, length, err := layout.Block(i); err == nil {
+				size = length
+			}
+
+			m.Blocks[i] = BlockInfo{
+				ID:     i,
+				Offset: offset,
+				Size:   size,
+				Hash:   formatHash(algorithm, r.next(algorithm.Size)),
+			}
+			offset += int64(size)
+		}
+	}
+
+	if sections&sectionFiles != 0 {
+		count := r.int()
+		if count < 0 || count > len(r.data) {
+			return nil, fmt.Errorf("%w: %d arquivos excede os dados", ErrInvalid, count)
+		}
+
+		m.Files = make([]FileEntry, count)
+		var offset int64
+		for i := range m.Files {
+			m.Files[i] = FileEntry{
+				Path:   r.string(),
+				Size:   int64(r.uint()),
+				Mode:   uint32(r.uint()),
+				Offset: offset,
+			}
+			offset += m.Files[i].Size
+		}
+	}
+
+	if sections&sectionMerkle != 0 {
+		m.Merkle = &MerkleInfo{
+			Leaf:      r.string(),
+			LeafCount: m.TotalBlocks,
+		}
+		m.Merkle.Root = EncodeMerkleRoot(r.next(32))
+	}
+
+	if sections&sectionSignature != 0 {
+		pub := r.next(32)
+		m.Signature = &Signature{Value: r.bytes()}
+		if pub != nil {
+			m.Signature.PublicKey = identity.EncodePublicKey(pub)
+		}
+	}
+
+	if sections&sectionLive != 0 {
+		pub := r.next(32)
+		m.Live = &LiveInfo{Seq: r.int()}
+		switch r.byte() {
+		case 0:
+		case 1:
+			m.Live.Final = true
+		default:
+			if r.err == nil {
+				r.err = errors.New("live.final inválido")
+			}
+		}
+		if pub != nil {
+			m.Live.Publisher = identity.EncodePublicKey(pub)
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.failure()
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("%w: %d bytes sobrando no formato binário", ErrInvalid, len(r.data))
+	}
+
+	return m, nil
+}
+
+// parseCanonicalHash decodifica um hash e exige a forma gerada por
+// formatHash, de modo que a conversão para binário não altere o texto
+func parseCanonicalHash(value string) (*checksum.Algorithm, []byte, error) {
+	algorithm, raw, err := checksum.ParseHash(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if formatHash(algorithm, raw) != value {
+		return nil, nil, fmt.Errorf("hash fora da forma canônica: %s", value)
+	}
+	return algorithm, raw, nil
+}
+
+// formatHash monta o texto "<algoritmo>:<hex>" a partir dos bytes crus
+func formatHash(algorithm *checksum.Algorithm, raw []byte) string {
+	return algorithm.Name + ":" + hex.EncodeToString(raw)
+}
+
+// binaryWriter acumula campos da codificação binária
+type binaryWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *binaryWriter) uint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+// binaryReader consome campos da codificação binária; o primeiro erro é
+// guardado e as leituras seguintes retornam valores zero
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+var errTruncated = errors.New("dados truncados")
+
+func (r *binaryReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// int lê um varint que precisa caber em int
+func (r *binaryReader) int() int {
+	v := r.uint()
+	if v > uint64(int(^uint(0)>>1)) {
+		r.err = fmt.Errorf("inteiro fora do intervalo: %d", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (r *binaryReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// next consome n bytes crus
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) bytes() []byte {
+	return r.next(r.int())
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+// failure descreve o erro de leitura em termos de ErrInvalid
+func (r *binaryReader) failure() error {
+	return fmt.Errorf("%w: formato binário: %v", ErrInvalid, r.err)
+}
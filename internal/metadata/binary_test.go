@@ -0,0 +1,166 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zatta/tp2-p2p/internal/chunker"
+	"github.com/zatta/tp2-p2p/internal/identity"
+)
+
+// writeTestFile grava conteúdo pseudoaleatório reprodutível
+func writeTestFile(t *testing.T, path string, size int, seed int64) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// binaryTestCases gera metadados de cada variante suportada pelo formato
+func binaryTestCases(t *testing.T) map[string]*Metadata {
+	t.Helper()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "arquivo.bin")
+	writeTestFile(t, file, 100000, 1)
+
+	tree := filepath.Join(dir, "arvore")
+	if err := os.MkdirAll(filepath.Join(tree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(tree, "a.bin"), 5000, 2)
+	writeTestFile(t, filepath.Join(tree, "sub", "b.bin"), 7000, 3)
+
+	publisher, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdc := chunker.DefaultParams(4096)
+
+	generate := func(path string, options GenerateOptions) *Metadata {
+		m, err := Generate(path, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	signed := generate(file, GenerateOptions{BlockSize: 4096})
+	if err := signed.Sign(publisher); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := GenerateLive(file, GenerateOptions{BlockSize: 4096}, publisher, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]*Metadata{
+		"blocos":    generate(file, GenerateOptions{BlockSize: 4096}),
+		"sha512":    generate(file, GenerateOptions{BlockSize: 4096, Hash: "sha512"}),
+		"merkle":    generate(file, GenerateOptions{BlockSize: 4096, Merkle: true}),
+		"cdc":       generate(file, GenerateOptions{Chunking: &cdc}),
+		"diretório": generate(tree, GenerateOptions{BlockSize: 1024}),
+		"assinado":  signed,
+		"ao vivo":   live,
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for name, m := range binaryTestCases(t) {
+		encoded, err := m.EncodeBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !IsBinary(encoded) {
+			t.Fatalf("%s: codificação sem o prefixo binário", name)
+		}
+
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		want, _ := m.Encode()
+		got, _ := decoded.Encode()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: metadados decodificados diferem do original\n%s\n%s", name, want, got)
+		}
+
+		wantHash, _ := m.InfoHash()
+		gotHash, _ := decoded.InfoHash()
+		if gotHash != wantHash {
+			t.Fatalf("%s: info hash mudou de %s para %s", name, wantHash, gotHash)
+		}
+
+		// Binário menor que o JSON (exceto no Merkle, que quase não tem blocos)
+		if !m.IsMerkle() && len(encoded) >= len(want) {
+			t.Errorf("%s: binário com %d bytes, JSON com %d", name, len(encoded), len(want))
+		}
+	}
+}
+
+func TestBinaryRejectsTruncated(t *testing.T) {
+	for name, m := range binaryTestCases(t) {
+		encoded, err := m.EncodeBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		for n := len(binaryMagic); n < len(encoded); n++ {
+			if _, err := Decode(encoded[:n]); err == nil {
+				t.Fatalf("%s: metadados truncados em %d de %d bytes aceitos", name, n, len(encoded))
+			}
+		}
+	}
+}
+
+func TestBinaryRejectsCorrupted(t *testing.T) {
+	m := binaryTestCases(t)["assinado"]
+	encoded, err := m.EncodeBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Um bit trocado em qualquer posição não pode produzir os mesmos metadados
+	want, _ := m.Encode()
+	for i := len(binaryMagic); i < len(encoded); i++ {
+		corrupted := append([]byte(nil), encoded...)
+		corrupted[i] ^= 0x01
+
+		decoded, err := Decode(corrupted)
+		if err != nil {
+			continue
+		}
+		if got, _ := decoded.Encode(); bytes.Equal(got, want) {
+			t.Fatalf("byte %d alterado sem efeito na decodificação", i)
+		}
+	}
+}
+
+func TestBinaryRejectsUnknownSections(t *testing.T) {
+	m := binaryTestCases(t)["blocos"]
+	encoded, err := m.EncodeBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// O byte de seções vem logo após o hash do arquivo
+	algorithm, fileHash, err := parseCanonicalHash(m.FileHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(encoded, fileHash) + algorithm.Size
+	encoded[at] |= 0x80
+
+	if _, err := Decode(encoded); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("seção desconhecida: %v, esperado ErrInvalid", err)
+	}
+}
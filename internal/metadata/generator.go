@@ -2,79 +2,269 @@ package metadata
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
 
 	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/chunker"
+	"github.com/zatta/tp2-p2p/internal/merkle"
+	"github.com/zatta/tp2-p2p/internal/storage"
 )
 
+// GenerateOptions contém os parâmetros da geração de metadados
+type GenerateOptions struct {
+	BlockSize int
+	Merkle    bool   // raiz de Merkle em vez da lista de blocos
+	Hash      string // algoritmo dos hashes do arquivo e dos blocos (padrão: checksum.DefaultAlgorithm)
+	Workers   int    // goroutines de hash dos blocos (padrão: GOMAXPROCS)
+
+	// Chunking, se definido, divide o conteúdo em blocos de tamanho variável
+	// definidos pelo conteúdo (FastCDC) e BlockSize é ignorado
+	Chunking *chunker.Params
+
+	// Progress, se definido, recebe os bytes já processados e o total
+	// (-1 quando desconhecido); é chamado de uma goroutine por vez
+	Progress func(done, total int64)
+}
+
+// Generate gera metadados de um arquivo ou, se path for um diretório, da
+// árvore de arquivos sob ele
+func Generate(path string, options GenerateOptions) (*Metadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao acessar %s: %w", path, err)
+	}
+
+	if info.IsDir() {
+		return generateFromDir(path, options)
+	}
+	return generateFromFile(path, options)
+}
+
+// GenerateFromReader gera metadados de um conteúdo lido de r (ex: stdin), cujo
+// tamanho não precisa ser conhecido; name é o nome gravado nos metadados
+func GenerateFromReader(r io.Reader, name string, options GenerateOptions) (*Metadata, error) {
+	metadata := &Metadata{
+		Version:   CurrentVersion,
+		FileName:  name,
+		BlockSize: options.BlockSize,
+	}
+
+	if err := metadata.fillBlocks(r, -1, options); err != nil {
+		return nil, err
+	}
+
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
 // GenerateFromFile gera metadados completos a partir de um arquivo
 func GenerateFromFile(filePath string, blockSize int) (*Metadata, error) {
+	return generateFromFile(filePath, GenerateOptions{BlockSize: blockSize})
+}
+
+// generateFromFile monta os metadados de um único arquivo
+func generateFromFile(filePath string, options GenerateOptions) (*Metadata, error) {
 	// Obtém tamanho do arquivo
 	fileSize, err := checksum.GetFileSize(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao obter tamanho do arquivo: %w", err)
 	}
 
-	// Calcula número total de blocos
-	totalBlocks := checksum.CalculateTotalBlocks(fileSize, blockSize)
+	metadata := &Metadata{
+		Version:   CurrentVersion,
+		FileName:  filepath.Base(filePath),
+		FileSize:  fileSize,
+		BlockSize: options.BlockSize,
+	}
 
-	// Calcula hash do arquivo completo
-	fileHash, err := checksum.CalculateFileChecksum(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("erro ao calcular hash do arquivo: %w", err)
+		return nil, fmt.Errorf("erro ao abrir arquivo: %w", err)
 	}
+	defer file.Close()
 
-	// Calcula checksums de todos os blocos
-	blockChecksums, err := checksum.CalculateFileBlocksChecksums(filePath, blockSize)
-	if err != nil {
-		return nil, fmt.Errorf("erro ao calcular checksums dos blocos: %w", err)
+	if err := metadata.fillBlocks(file, fileSize, options); err != nil {
+		return nil, err
 	}
 
-	// Cria lista de informações dos blocos
-	blocks := make([]BlockInfo, totalBlocks)
-	for i := 0; i < totalBlocks; i++ {
-		offset := int64(i) * int64(blockSize)
-		size := blockSize
-
-		// Último bloco pode ser menor
-		if i == totalBlocks-1 {
-			remainingSize := int(fileSize - offset)
-			if remainingSize < blockSize {
-				size = remainingSize
-			}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// generateFromDir monta os metadados de uma árvore de arquivos
+func generateFromDir(dirPath string, options GenerateOptions) (*Metadata, error) {
+	var entries []FileEntry
+	var totalSize int64
+
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
 		}
 
-		blocks[i] = BlockInfo{
-			ID:     i,
-			Offset: offset,
-			Size:   size,
-			Hash:   blockChecksums[i],
+		info, err := d.Info()
+		if err != nil {
+			return err
 		}
+
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, FileEntry{
+			Path:   filepath.ToSlash(rel),
+			Size:   info.Size(),
+			Mode:   uint32(info.Mode().Perm()),
+			Offset: totalSize,
+		})
+		totalSize += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao percorrer diretório: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("diretório sem arquivos: %s", dirPath)
 	}
 
-	// Cria estrutura de metadados
 	metadata := &Metadata{
-		FileName:    filepath.Base(filePath),
-		FileSize:    fileSize,
-		BlockSize:   blockSize,
-		TotalBlocks: totalBlocks,
-		FileHash:    fileHash,
-		Blocks:      blocks,
+		Version:   CurrentVersion,
+		FileName:  filepath.Base(filepath.Clean(dirPath)),
+		FileSize:  totalSize,
+		BlockSize: options.BlockSize,
+		Files:     entries,
+	}
+
+	store, err := metadata.OpenStorage(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := store.NewReader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if err := metadata.fillBlocks(reader, totalSize, options); err != nil {
+		return nil, err
+	}
+
+	if err := metadata.Validate(); err != nil {
+		return nil, err
 	}
 
 	return metadata, nil
 }
 
-// GenerateAndSave gera metadados e salva em arquivo
-func GenerateAndSave(filePath string, blockSize int, outputPath string) error {
-	metadata, err := GenerateFromFile(filePath, blockSize)
+// fillBlocks calcula, em uma única leitura de r, o hash completo e os hashes
+// (ou a árvore) dos blocos. expectedSize é o tamanho conhecido do conteúdo, ou
+// -1 para aceitar o que for lido.
+func (m *Metadata) fillBlocks(r io.Reader, expectedSize int64, options GenerateOptions) error {
+	hashName := options.Hash
+	if hashName == "" {
+		hashName = checksum.DefaultAlgorithm
+	}
+	algorithm, err := checksum.Lookup(hashName)
 	if err != nil {
 		return err
 	}
 
-	if err := metadata.SaveToFile(outputPath); err != nil {
+	var next func() ([]byte, error)
+	if options.Chunking != nil {
+		if options.Merkle {
+			return fmt.Errorf("chunking por conteúdo não é suportado no modo Merkle")
+		}
+		c, err := chunker.New(r, *options.Chunking)
+		if err != nil {
+			return err
+		}
+		next = c.Next
+
+		m.BlockSize = options.Chunking.Max
+		m.Chunking = &ChunkingInfo{
+			Algorithm: chunker.Algorithm,
+			MinSize:   options.Chunking.Min,
+			AvgSize:   options.Chunking.Avg,
+			MaxSize:   options.Chunking.Max,
+		}
+	} else {
+		if m.BlockSize <= 0 {
+			return fmt.Errorf("tamanho de bloco inválido: %d", m.BlockSize)
+		}
+		next = fixedBlocks(r, m.BlockSize)
+	}
+
+	digests, err := digestStream(next, algorithm, options, expectedSize)
+	if err != nil {
 		return err
 	}
 
+	// O conteúdo pode ter mudado entre o stat e a leitura
+	if expectedSize >= 0 && digests.size != expectedSize {
+		return fmt.Errorf("conteúdo alterado durante a geração: esperado %d bytes, lidos %d", expectedSize, digests.size)
+	}
+
+	m.FileSize = digests.size
+	m.FileHash = digests.fileHash
+	m.TotalBlocks = len(digests.blocks)
+
+	if options.Merkle {
+		leaves := make([][]byte, len(digests.blocks))
+		for i, block := range digests.blocks {
+			leaves[i] = block.leaf
+		}
+		tree, err := merkle.Build(leaves)
+		if err != nil {
+			return err
+		}
+
+		m.Merkle = &MerkleInfo{
+			Root:      EncodeMerkleRoot(tree.Root()),
+			LeafCount: m.TotalBlocks,
+			Leaf:      MerkleLeafSHA256,
+		}
+		return nil
+	}
+
+	// Cria lista de informações dos blocos
+	m.Blocks = make([]BlockInfo, len(digests.blocks))
+	var offset int64
+	for i, block := range digests.blocks {
+		m.Blocks[i] = BlockInfo{
+			ID:     i,
+			Offset: offset,
+			Size:   block.size,
+			Hash:   block.hash,
+		}
+		offset += int64(block.size)
+	}
+
 	return nil
 }
+
+// BuildMerkleTree lê os blocos do conteúdo e monta a árvore completa
+func BuildMerkleTree(store storage.Storage, totalBlocks int) (*merkle.Tree, error) {
+	leaves := make([][]byte, totalBlocks)
+	for i := 0; i < totalBlocks; i++ {
+		data, err := store.ReadBlock(i)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler bloco %d: %w", i, err)
+		}
+		leaves[i] = merkle.LeafHash(data)
+	}
+
+	return merkle.Build(leaves)
+}
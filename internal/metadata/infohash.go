@@ -0,0 +1,38 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// infoHashContext separa o info hash de outros hashes sobre os mesmos bytes
+const infoHashContext = "p2psd-infohash-v1\n"
+
+// InfoHash identifica o swarm do conteúdo: SHA-256 (em hex) da codificação
+// canônica dos metadados. Cobre nome, tamanhos, layout e hashes dos blocos,
+// mas não a assinatura, de modo que assinar de novo não muda o swarm. Em
+// metadados ao vivo, os campos que crescem com as extensões ficam de fora.
+func (m *Metadata) InfoHash() (string, error) {
+	data, err := m.identityBytes()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(append([]byte(infoHashContext), data...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// ParseInfoHash valida um info hash informado pelo usuário e o normaliza para
+// hex minúsculo
+func ParseInfoHash(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("info hash inválido: %q (esperado %d bytes em hex)", s, sha256.Size)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,269 @@
+package metadata
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zatta/tp2-p2p/internal/identity"
+)
+
+// ErrNoNewBlocks indica que o conteúdo ao vivo não cresceu um bloco completo
+var ErrNoNewBlocks = errors.New("nenhum bloco novo")
+
+// LiveInfo marca metadados de um conteúdo que ainda está sendo escrito (log,
+// gravação). O publicador emite extensões assinadas com os blocos novos; o
+// info hash ignora os campos que crescem, de modo que o swarm não muda.
+type LiveInfo struct {
+	Publisher string `json:"publisher"`       // formato "ed25519:<hex>"; única chave aceita nas extensões
+	Seq       int    `json:"seq"`             // número da extensão (0 = metadados iniciais)
+	Final     bool   `json:"final,omitempty"` // conteúdo encerrado, sem novas extensões
+}
+
+// Extension acrescenta blocos a metadados ao vivo. A assinatura cobre os
+// metadados completos resultantes, não apenas a extensão.
+type Extension struct {
+	InfoHash   string      `json:"info_hash"`
+	Seq        int         `json:"seq"`
+	FileSize   int64       `json:"file_size"`
+	FileHash   string      `json:"file_hash"`
+	FirstBlock int         `json:"first_block"`
+	Blocks     []BlockInfo `json:"blocks"`
+	Final      bool        `json:"final,omitempty"`
+	Signature  *Signature  `json:"signature"`
+}
+
+// IsLive verifica se os metadados descrevem um conteúdo que ainda cresce
+func (m *Metadata) IsLive() bool {
+	return m.Live != nil
+}
+
+// validateLive confere as restrições do modo ao vivo: lista de blocos de
+// tamanho fixo em um único arquivo e, enquanto não encerrado, apenas blocos
+// completos (o último bloco parcial só entra na extensão final)
+func (m *Metadata) validateLive() error {
+	switch {
+	case m.IsMerkle():
+		return invalid("live e merkle são exclusivos")
+	case m.IsChunked():
+		return invalid("live e chunking são exclusivos")
+	case m.IsMultiFile():
+		return invalid("live não suporta diretórios")
+	case m.Live.Seq < 0:
+		return invalid("live.seq negativo: %d", m.Live.Seq)
+	case !m.Live.Final && m.FileSize%int64(m.BlockSize) != 0:
+		return invalid("file_size = %d não é múltiplo de block_size antes da extensão final", m.FileSize)
+	}
+
+	if _, err := identity.ParsePublicKey(m.Live.Publisher); err != nil {
+		return invalid("live.publisher: %v", err)
+	}
+	return nil
+}
+
+// identityBytes retorna o que identifica o swarm: os metadados canônicos ou,
+// no modo ao vivo, apenas os campos que não mudam com as extensões
+func (m *Metadata) identityBytes() ([]byte, error) {
+	if !m.IsLive() {
+		return m.canonicalBytes()
+	}
+
+	genesis := *m
+	genesis.FileSize = 0
+	genesis.TotalBlocks = 0
+	genesis.FileHash = ""
+	genesis.Blocks = nil
+	genesis.Live = &LiveInfo{Publisher: m.Live.Publisher}
+
+	return genesis.canonicalBytes()
+}
+
+// VerifyPublisher verifica se metadados ao vivo foram assinados pela chave
+// declarada em live.publisher, que faz parte do info hash
+func (m *Metadata) VerifyPublisher() error {
+	if !m.IsLive() {
+		return fmt.Errorf("metadados não são ao vivo")
+	}
+
+	publisher, err := identity.ParsePublicKey(m.Live.Publisher)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return m.VerifySignature([]ed25519.PublicKey{publisher})
+}
+
+// ExtensionFrom monta a extensão que leva metadados com firstBlock blocos até
+// estes metadados
+func (m *Metadata) ExtensionFrom(firstBlock int) (*Extension, error) {
+	if !m.IsLive() {
+		return nil, fmt.Errorf("metadados não são ao vivo")
+	}
+	if firstBlock < 0 || firstBlock > m.TotalBlocks {
+		return nil, fmt.Errorf("bloco inicial %d fora do intervalo [0, %d]", firstBlock, m.TotalBlocks)
+	}
+
+	infoHash, err := m.InfoHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Extension{
+		InfoHash:   infoHash,
+		Seq:        m.Live.Seq,
+		FileSize:   m.FileSize,
+		FileHash:   m.FileHash,
+		FirstBlock: firstBlock,
+		Blocks:     append([]BlockInfo(nil), m.Blocks[firstBlock:]...),
+		Final:      m.Live.Final,
+		Signature:  m.Signature,
+	}, nil
+}
+
+// ApplyExtension retorna novos metadados com os blocos da extensão, depois de
+// conferir a sequência, a consistência do resultado e a assinatura do
+// publicador. Os metadados originais não são alterados.
+func (m *Metadata) ApplyExtension(ext *Extension) (*Metadata, error) {
+	if !m.IsLive() {
+		return nil, fmt.Errorf("metadados não são ao vivo")
+	}
+	if m.Live.Final {
+		return nil, fmt.Errorf("conteúdo ao vivo já encerrado na extensão %d", m.Live.Seq)
+	}
+
+	infoHash, err := m.InfoHash()
+	if err != nil {
+		return nil, err
+	}
+	if ext.InfoHash != infoHash {
+		return nil, fmt.Errorf("extensão de outro conteúdo: %s", ext.InfoHash)
+	}
+	if ext.Seq <= m.Live.Seq {
+		return nil, fmt.Errorf("extensão %d não é posterior à %d", ext.Seq, m.Live.Seq)
+	}
+	if ext.FirstBlock != m.TotalBlocks {
+		return nil, fmt.Errorf("extensão começa no bloco %d, esperado %d", ext.FirstBlock, m.TotalBlocks)
+	}
+
+	next := *m
+	next.Version = CurrentVersion
+	next.FileSize = ext.FileSize
+	next.FileHash = ext.FileHash
+	next.TotalBlocks = m.TotalBlocks + len(ext.Blocks)
+	next.Blocks = append(m.Blocks[:len(m.Blocks):len(m.Blocks)], ext.Blocks...)
+	next.Live = &LiveInfo{Publisher: m.Live.Publisher, Seq: ext.Seq, Final: ext.Final}
+	next.Signature = ext.Signature
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if err := next.VerifyPublisher(); err != nil {
+		return nil, err
+	}
+
+	return &next, nil
+}
+
+// GenerateLive gera os metadados iniciais de um arquivo que ainda está sendo
+// escrito, assinados pelo publicador. Sem final, só entram os blocos completos.
+func GenerateLive(filePath string, options GenerateOptions, publisher *identity.Identity, final bool) (*Metadata, error) {
+	if options.Merkle || options.Chunking != nil {
+		return nil, fmt.Errorf("modo ao vivo não suporta Merkle nem chunking")
+	}
+
+	live := LiveInfo{Publisher: identity.EncodePublicKey(publisher.PublicKey), Final: final}
+	return generateLive(filePath, filepath.Base(filePath), options, live, publisher)
+}
+
+// Extend gera a próxima versão dos metadados ao vivo a partir do arquivo, que
+// só pode ter crescido. Retorna ErrNoNewBlocks se não há bloco completo novo e
+// final não foi pedido.
+func (m *Metadata) Extend(filePath string, publisher *identity.Identity, final bool) (*Metadata, error) {
+	if !m.IsLive() {
+		return nil, fmt.Errorf("metadados não são ao vivo")
+	}
+	if m.Live.Final {
+		return nil, fmt.Errorf("conteúdo ao vivo já encerrado na extensão %d", m.Live.Seq)
+	}
+	if key := identity.EncodePublicKey(publisher.PublicKey); key != m.Live.Publisher {
+		return nil, fmt.Errorf("chave %s não é a do publicador %s", key, m.Live.Publisher)
+	}
+
+	algorithm, err := m.HashAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	options := GenerateOptions{BlockSize: m.BlockSize, Hash: algorithm.Name}
+	live := LiveInfo{Publisher: m.Live.Publisher, Seq: m.Live.Seq + 1, Final: final}
+	next, err := generateLive(filePath, m.FileName, options, live, publisher)
+	if err != nil {
+		return nil, err
+	}
+
+	// Conteúdo ao vivo só cresce: os blocos já publicados não podem mudar
+	if next.FileSize < m.FileSize {
+		return nil, fmt.Errorf("arquivo encolheu de %d para %d bytes", m.FileSize, next.FileSize)
+	}
+	for i, block := range m.Blocks {
+		if next.Blocks[i].Hash != block.Hash {
+			return nil, fmt.Errorf("bloco %d alterado: conteúdo ao vivo só pode crescer", i)
+		}
+	}
+	if next.TotalBlocks == m.TotalBlocks && !final {
+		return nil, ErrNoNewBlocks
+	}
+
+	return next, nil
+}
+
+// generateLive gera e assina os metadados do prefixo publicável do arquivo
+func generateLive(filePath, name string, options GenerateOptions, live LiveInfo, publisher *identity.Identity) (*Metadata, error) {
+	if options.BlockSize <= 0 {
+		return nil, fmt.Errorf("tamanho de bloco inválido: %d", options.BlockSize)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir arquivo: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter tamanho do arquivo: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("modo ao vivo não suporta diretórios: %s", filePath)
+	}
+
+	// O bloco parcial no fim ainda pode mudar até a extensão final
+	size := info.Size()
+	if !live.Final {
+		size -= size % int64(options.BlockSize)
+	}
+
+	metadata := &Metadata{
+		Version:   CurrentVersion,
+		FileName:  name,
+		FileSize:  size,
+		BlockSize: options.BlockSize,
+		Live:      &live,
+	}
+
+	if err := metadata.fillBlocks(io.LimitReader(file, size), size, options); err != nil {
+		return nil, err
+	}
+
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := metadata.Sign(publisher); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
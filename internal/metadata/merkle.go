@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// MerkleLeafSHA256 define a folha como SHA-256 do bloco com prefixo de domínio
+// (ver merkle.LeafHash)
+const MerkleLeafSHA256 = "sha256-block"
+
+// merkleRootPrefix identifica o algoritmo da raiz
+const merkleRootPrefix = "sha256:"
+
+// EncodeMerkleRoot formata a raiz para os metadados
+func EncodeMerkleRoot(root []byte) string {
+	return merkleRootPrefix + hex.EncodeToString(root)
+}
+
+// MerkleRoot decodifica a raiz da árvore descrita nos metadados
+func (m *Metadata) MerkleRoot() ([]byte, error) {
+	if m.Merkle == nil {
+		return nil, fmt.Errorf("metadados não estão no modo Merkle")
+	}
+	if m.Merkle.Leaf != MerkleLeafSHA256 {
+		return nil, fmt.Errorf("tipo de folha não suportado: %s", m.Merkle.Leaf)
+	}
+	if m.Merkle.LeafCount != m.TotalBlocks {
+		return nil, fmt.Errorf("árvore com %d folhas para %d blocos", m.Merkle.LeafCount, m.TotalBlocks)
+	}
+
+	encoded, ok := strings.CutPrefix(m.Merkle.Root, merkleRootPrefix)
+	if !ok {
+		return nil, fmt.Errorf("raiz de Merkle sem prefixo %q", merkleRootPrefix)
+	}
+
+	root, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("raiz de Merkle inválida: %w", err)
+	}
+
+	return root, nil
+}
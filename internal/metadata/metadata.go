@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/storage"
 )
 
 // BlockInfo representa informações sobre um bloco específico
@@ -16,12 +20,56 @@ type BlockInfo struct {
 
 // Metadata contém todas as informações sobre um arquivo compartilhado
 type Metadata struct {
-	FileName    string      `json:"file_name"`
-	FileSize    int64       `json:"file_size"`
-	BlockSize   int         `json:"block_size"`
-	TotalBlocks int         `json:"total_blocks"`
-	FileHash    string      `json:"file_hash"`
-	Blocks      []BlockInfo `json:"blocks"`
+	Version     int           `json:"version,omitempty"` // ver CurrentVersion
+	FileName    string        `json:"file_name"`
+	FileSize    int64         `json:"file_size"`
+	BlockSize   int           `json:"block_size"`
+	TotalBlocks int           `json:"total_blocks"`
+	FileHash    string        `json:"file_hash"`
+	Blocks      []BlockInfo   `json:"blocks,omitempty"`
+	Files       []FileEntry   `json:"files,omitempty"`
+	Merkle      *MerkleInfo   `json:"merkle,omitempty"`
+	Chunking    *ChunkingInfo `json:"chunking,omitempty"`
+	Live        *LiveInfo     `json:"live,omitempty"`
+	Signature   *Signature    `json:"signature,omitempty"`
+}
+
+// FileEntry descreve um arquivo de um diretório compartilhado. Os arquivos
+// são concatenados na ordem da lista para formar o espaço contínuo de blocos,
+// de modo que um bloco pode cobrir o fim de um arquivo e o início do próximo.
+type FileEntry struct {
+	Path   string `json:"path"`   // relativo ao diretório FileName, separado por "/"
+	Size   int64  `json:"size"`   // em bytes
+	Mode   uint32 `json:"mode"`   // permissões Unix (ex: 0644)
+	Offset int64  `json:"offset"` // posição do arquivo no espaço de blocos
+}
+
+// MerkleInfo descreve a árvore de Merkle dos blocos. Nesse modo os metadados
+// não listam os blocos: cada bloco viaja com a prova até a raiz.
+type MerkleInfo struct {
+	Root      string `json:"root"`       // formato "sha256:<hex>"
+	LeafCount int    `json:"leaf_count"` // igual a TotalBlocks
+	Leaf      string `json:"leaf"`       // definição da folha ("sha256-block")
+}
+
+// ChunkingInfo descreve a divisão do conteúdo em blocos definidos pelo próprio
+// conteúdo. Nesse modo os blocos têm tamanho variável, a posição de cada um
+// vem da lista de blocos e block_size é o tamanho máximo.
+type ChunkingInfo struct {
+	Algorithm string `json:"algorithm"` // "fastcdc"
+	MinSize   int    `json:"min_size"`
+	AvgSize   int    `json:"avg_size"`
+	MaxSize   int    `json:"max_size"`
+}
+
+// IsMerkle verifica se os metadados estão no modo árvore de Merkle
+func (m *Metadata) IsMerkle() bool {
+	return m.Merkle != nil
+}
+
+// IsChunked verifica se os blocos têm tamanho variável (chunking por conteúdo)
+func (m *Metadata) IsChunked() bool {
+	return m.Chunking != nil
 }
 
 // SaveToFile salva os metadados em um arquivo JSON
@@ -38,26 +86,121 @@ func (m *Metadata) SaveToFile(filePath string) error {
 	return nil
 }
 
-// LoadFromFile carrega metadados de um arquivo JSON
+// LoadFromFile carrega metadados de um arquivo JSON ou binário (detectado
+// pelo conteúdo)
 func LoadFromFile(filePath string) (*Metadata, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao ler arquivo: %w", err)
 	}
 
+	return Decode(data)
+}
+
+// Encode serializa os metadados (com a assinatura) para envio a outros peers
+func (m *Metadata) Encode() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar metadados: %w", err)
+	}
+
+	return data, nil
+}
+
+// Decode reconstrói metadados serializados por Encode, EncodeBinary ou
+// SaveToFile, migrando versões anteriores do formato e validando o resultado
+func Decode(data []byte) (*Metadata, error) {
 	var metadata Metadata
-	if err := json.Unmarshal(data, &metadata); err != nil {
+	if IsBinary(data) {
+		decoded, err := decodeBinary(data)
+		if err != nil {
+			return nil, err
+		}
+		metadata = *decoded
+	} else if err := json.Unmarshal(data, &metadata); err != nil {
 		return nil, fmt.Errorf("erro ao deserializar metadados: %w", err)
 	}
 
+	if err := metadata.migrate(); err != nil {
+		return nil, err
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &metadata, nil
 }
 
+// IsMultiFile verifica se os metadados descrevem um diretório
+func (m *Metadata) IsMultiFile() bool {
+	return len(m.Files) > 0
+}
+
+// HashAlgorithm retorna o algoritmo dos hashes do arquivo e dos blocos
+func (m *Metadata) HashAlgorithm() (*checksum.Algorithm, error) {
+	algorithm, _, err := checksum.ParseHash(m.FileHash)
+	return algorithm, err
+}
+
+// Layout retorna a posição dos blocos no conteúdo: calculada a partir de
+// block_size ou, com chunking, a declarada na lista de blocos
+func (m *Metadata) Layout() storage.Layout {
+	if !m.IsChunked() {
+		return storage.FixedLayout{Size: m.FileSize, BlockSize: m.BlockSize}
+	}
+
+	extents := make(storage.ExtentLayout, len(m.Blocks))
+	for i, block := range m.Blocks {
+		extents[i] = storage.Extent{Offset: block.Offset, Size: block.Size}
+	}
+	return extents
+}
+
+// OpenStorage abre o conteúdo descrito pelos metadados em path: o próprio
+// arquivo ou, para diretórios, a raiz sob a qual ficam os arquivos listados
+func (m *Metadata) OpenStorage(path string) (storage.Storage, error) {
+	if !m.IsMultiFile() {
+		return storage.NewSingleFile(path, m.FileSize, m.Layout()), nil
+	}
+
+	files := make([]storage.File, len(m.Files))
+	for i, f := range m.Files {
+		files[i] = storage.File{
+			Path: f.Path,
+			Size: f.Size,
+			Mode: os.FileMode(f.Mode).Perm(),
+		}
+	}
+
+	store, err := storage.NewMultiFile(filepath.Clean(path), files, m.Layout())
+	if err != nil {
+		return nil, fmt.Errorf("lista de arquivos inválida: %w", err)
+	}
+
+	// Posições declaradas devem corresponder à concatenação dos arquivos
+	var total int64
+	for i, offset := range store.Offsets() {
+		if m.Files[i].Offset != offset {
+			return nil, fmt.Errorf("arquivo %s na posição %d, esperado %d", m.Files[i].Path, m.Files[i].Offset, offset)
+		}
+		total += m.Files[i].Size
+	}
+	if total != m.FileSize {
+		return nil, fmt.Errorf("soma dos arquivos (%d bytes) difere de file_size (%d bytes)", total, m.FileSize)
+	}
+
+	return store, nil
+}
+
 // GetBlock retorna informações sobre um bloco específico
 func (m *Metadata) GetBlock(blockID int) (*BlockInfo, error) {
 	if blockID < 0 || blockID >= m.TotalBlocks {
 		return nil, fmt.Errorf("ID de bloco inválido: %d (total: %d)", blockID, m.TotalBlocks)
 	}
 
+	if blockID >= len(m.Blocks) {
+		return nil, fmt.Errorf("metadados não listam o bloco %d (modo Merkle)", blockID)
+	}
+
 	return &m.Blocks[blockID], nil
 }
@@ -0,0 +1,137 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/merkle"
+)
+
+// blockJob é um bloco lido aguardando hash
+type blockJob struct {
+	id   int
+	data []byte
+}
+
+// blockDigest é o resultado do hash de um bloco
+type blockDigest struct {
+	id   int
+	size int
+	hash string // hash formatado (modo lista)
+	leaf []byte // folha da árvore (modo Merkle)
+}
+
+// streamDigests reúne o que uma leitura do conteúdo produz
+type streamDigests struct {
+	fileHash string
+	size     int64
+	blocks   []blockDigest
+}
+
+// digestStream lê o conteúdo uma única vez: o hash completo é calculado em
+// sequência enquanto os blocos produzidos por next são distribuídos entre os
+// workers. total é o tamanho esperado, ou -1 se desconhecido (ex: stdin), e só
+// serve ao progresso.
+func digestStream(next func() ([]byte, error), algorithm *checksum.Algorithm, options GenerateOptions, total int64) (*streamDigests, error) {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	jobs := make(chan blockJob, 2*workers)
+	results := make(chan blockDigest, 2*workers)
+
+	// Workers: hash de cada bloco, independente dos demais
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				digest := blockDigest{id: job.id, size: len(job.data)}
+				if options.Merkle {
+					digest.leaf = merkle.LeafHash(job.data)
+				} else {
+					digest.hash = algorithm.Sum(job.data)
+				}
+				results <- digest
+			}
+		}()
+	}
+
+	// Coletor: guarda os resultados na ordem dos blocos e informa o progresso
+	var blocks []blockDigest
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		var done int64
+		for res := range results {
+			for len(blocks) <= res.id {
+				blocks = append(blocks, blockDigest{})
+			}
+			blocks[res.id] = res
+
+			done += int64(res.size)
+			if options.Progress != nil {
+				options.Progress(done, total)
+			}
+		}
+	}()
+
+	fileHash := algorithm.New()
+	size, readErr := readBlocks(next, fileHash, jobs)
+
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-collected
+
+	if readErr != nil {
+		return nil, fmt.Errorf("erro ao ler conteúdo: %w", readErr)
+	}
+
+	return &streamDigests{
+		fileHash: algorithm.Format(fileHash),
+		size:     size,
+		blocks:   blocks,
+	}, nil
+}
+
+// readBlocks consome os blocos de next, alimenta o hash completo e envia cada
+// bloco aos workers
+func readBlocks(next func() ([]byte, error), fileHash hash.Hash, jobs chan<- blockJob) (int64, error) {
+	var size int64
+	for id := 0; ; id++ {
+		data, err := next()
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+
+		fileHash.Write(data)
+		jobs <- blockJob{id: id, data: data}
+		size += int64(len(data))
+	}
+}
+
+// fixedBlocks divide r em blocos de blockSize bytes; o último pode ser menor
+func fixedBlocks(r io.Reader, blockSize int) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		data := make([]byte, blockSize)
+		n, err := io.ReadFull(r, data)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+}
@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zatta/tp2-p2p/internal/identity"
+)
+
+// signatureContext separa assinaturas de metadados de outros usos da chave
+const signatureContext = "p2psd-metadata-v1\n"
+
+// Erros de verificação da assinatura do publicador
+var (
+	ErrUnsigned           = errors.New("metadados não assinados")
+	ErrUntrustedPublisher = errors.New("publicador não confiável")
+	ErrInvalidSignature   = errors.New("assinatura inválida: metadados foram alterados")
+)
+
+// Signature contém a assinatura do publicador sobre os metadados
+type Signature struct {
+	PublicKey string `json:"public_key"` // formato "ed25519:<hex>"
+	Value     []byte `json:"value"`
+}
+
+// canonicalBytes retorna a codificação canônica dos metadados, sem a assinatura
+func (m *Metadata) canonicalBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+
+	// A versão do formato não faz parte do conteúdo: migrar metadados antigos
+	// não invalida assinaturas nem muda o info hash
+	unsigned.Version = 0
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar metadados: %w", err)
+	}
+
+	return data, nil
+}
+
+// Sign assina os metadados com a chave do publicador
+func (m *Metadata) Sign(publisher *identity.Identity) error {
+	data, err := m.canonicalBytes()
+	if err != nil {
+		return err
+	}
+
+	m.Signature = &Signature{
+		PublicKey: identity.EncodePublicKey(publisher.PublicKey),
+		Value:     publisher.Sign(append([]byte(signatureContext), data...)),
+	}
+
+	return nil
+}
+
+// VerifySignature verifica se os metadados foram assinados por um dos
+// publicadores confiáveis e não foram alterados desde então
+func (m *Metadata) VerifySignature(trusted []ed25519.PublicKey) error {
+	if m.Signature == nil {
+		return ErrUnsigned
+	}
+
+	pub, err := identity.ParsePublicKey(m.Signature.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	isTrusted := false
+	for _, key := range trusted {
+		if key.Equal(pub) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return fmt.Errorf("%w: %s", ErrUntrustedPublisher, m.Signature.PublicKey)
+	}
+
+	data, err := m.canonicalBytes()
+	if err != nil {
+		return err
+	}
+
+	if !identity.Verify(pub, append([]byte(signatureContext), data...), m.Signature.Value) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// ParseTrustedKeys converte as chaves públicas configuradas ("ed25519:<hex>")
+func ParseTrustedKeys(keys []string) ([]ed25519.PublicKey, error) {
+	parsed := make([]ed25519.PublicKey, 0, len(keys))
+
+	for _, key := range keys {
+		pub, err := identity.ParsePublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, pub)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,234 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/chunker"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+// CurrentVersion é a versão do formato gravada por SaveToFile.
+//
+//	1: formato original (sem o campo version), com a lista de blocos
+//	2: lista de blocos opcional (modo Merkle), diretórios e assinatura
+//	3: blocos de tamanho variável (chunking)
+//	4: conteúdo ao vivo, estendido por extensões assinadas
+const CurrentVersion = 4
+
+// ErrInvalid indica metadados inconsistentes; a mensagem aponta o campo
+var ErrInvalid = errors.New("metadados inválidos")
+
+// invalid formata um erro de validação que envolve ErrInvalid
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// migrate atualiza metadados de versões anteriores para CurrentVersion. A
+// versão não entra na codificação canônica, então a migração não muda o info
+// hash nem invalida assinaturas.
+func (m *Metadata) migrate() error {
+	if m.Version > CurrentVersion {
+		return fmt.Errorf("versão %d dos metadados não suportada (máxima: %d)", m.Version, CurrentVersion)
+	}
+
+	// Arquivos sem o campo são do formato original
+	if m.Version == 0 {
+		m.Version = 1
+	}
+
+	// 1 -> 2, 2 -> 3 e 3 -> 4: apenas campos opcionais foram acrescentados
+	if m.Version == 1 {
+		m.Version = 2
+	}
+	if m.Version == 2 {
+		m.Version = 3
+	}
+	if m.Version == 3 {
+		m.Version = 4
+	}
+
+	return nil
+}
+
+// Validate confere a consistência interna dos metadados: parâmetros dos
+// blocos, hashes, nome e lista de arquivos. Metadados recebidos de terceiros
+// devem passar por aqui antes de qualquer acesso ao disco.
+func (m *Metadata) Validate() error {
+	if err := checkFileName(m.FileName); err != nil {
+		return err
+	}
+	if m.FileSize < 0 {
+		return invalid("file_size negativo: %d", m.FileSize)
+	}
+	if m.BlockSize <= 0 {
+		return invalid("block_size deve ser positivo: %d", m.BlockSize)
+	}
+	if m.IsChunked() {
+		if err := m.validateChunking(); err != nil {
+			return err
+		}
+	} else if expected := checksum.CalculateTotalBlocks(m.FileSize, m.BlockSize); m.TotalBlocks != expected {
+		return invalid("total_blocks = %d, esperado %d para %d bytes em blocos de %d", m.TotalBlocks, expected, m.FileSize, m.BlockSize)
+	}
+
+	algorithm, _, err := checksum.ParseHash(m.FileHash)
+	if err != nil {
+		return invalid("file_hash: %v", err)
+	}
+
+	if m.IsMerkle() {
+		if len(m.Blocks) > 0 {
+			return invalid("blocks e merkle são exclusivos")
+		}
+		root, err := m.MerkleRoot()
+		if err != nil {
+			return invalid("merkle: %v", err)
+		}
+		if len(root) != sha256.Size {
+			return invalid("merkle.root com %d bytes, esperado %d", len(root), sha256.Size)
+		}
+	} else if err := m.validateBlocks(algorithm); err != nil {
+		return err
+	}
+
+	if m.IsMultiFile() {
+		if err := m.validateFiles(); err != nil {
+			return err
+		}
+	}
+
+	if m.IsLive() {
+		return m.validateLive()
+	}
+
+	return nil
+}
+
+// validateChunking confere os parâmetros do chunking por conteúdo, que exige
+// a lista de blocos para localizar cada bloco
+func (m *Metadata) validateChunking() error {
+	c := m.Chunking
+	if c.Algorithm != chunker.Algorithm {
+		return invalid("chunking.algorithm desconhecido: %q", c.Algorithm)
+	}
+	params := chunker.Params{Min: c.MinSize, Avg: c.AvgSize, Max: c.MaxSize}
+	if err := params.Validate(); err != nil {
+		return invalid("chunking: %v", err)
+	}
+	if m.BlockSize != c.MaxSize {
+		return invalid("block_size = %d, esperado max_size = %d", m.BlockSize, c.MaxSize)
+	}
+	if m.IsMerkle() {
+		return invalid("chunking e merkle são exclusivos")
+	}
+	return nil
+}
+
+// validateBlocks confere se a lista de blocos cobre o conteúdo em sequência,
+// sem lacunas, e exige que os hashes usem o mesmo algoritmo do arquivo. Sem
+// chunking, todos os blocos exceto o último têm block_size bytes.
+func (m *Metadata) validateBlocks(algorithm *checksum.Algorithm) error {
+	if len(m.Blocks) != m.TotalBlocks {
+		return invalid("%d blocos listados, esperado %d", len(m.Blocks), m.TotalBlocks)
+	}
+
+	var offset int64
+	for i, block := range m.Blocks {
+		last := i == len(m.Blocks)-1
+
+		switch {
+		case block.ID != i:
+			return invalid("blocks[%d].id = %d", i, block.ID)
+		case block.Offset != offset:
+			return invalid("blocks[%d].offset = %d, esperado %d", i, block.Offset, offset)
+		case block.Size <= 0 || block.Size > m.BlockSize:
+			return invalid("blocks[%d].size = %d, fora de 1..%d", i, block.Size, m.BlockSize)
+		case !m.IsChunked() && !last && block.Size != m.BlockSize:
+			return invalid("blocks[%d].size = %d, esperado %d", i, block.Size, m.BlockSize)
+		}
+
+		blockAlgorithm, _, err := checksum.ParseHash(block.Hash)
+		if err != nil {
+			return invalid("blocks[%d].hash: %v", i, err)
+		}
+		if blockAlgorithm != algorithm {
+			return invalid("blocks[%d].hash usa %s, arquivo usa %s", i, blockAlgorithm.Name, algorithm.Name)
+		}
+
+		offset += int64(block.Size)
+	}
+
+	if offset != m.FileSize {
+		return invalid("blocos somam %d bytes, file_size = %d", offset, m.FileSize)
+	}
+
+	return nil
+}
+
+// validateFiles confere caminhos, permissões e a cobertura contínua do espaço
+// de blocos pela lista de arquivos
+func (m *Metadata) validateFiles() error {
+	var offset int64
+	paths := make(map[string]bool, len(m.Files))
+
+	for i, f := range m.Files {
+		if err := storage.CheckPath(f.Path); err != nil {
+			return invalid("files[%d].path: %v", i, err)
+		}
+		if paths[f.Path] {
+			return invalid("files[%d].path duplicado: %s", i, f.Path)
+		}
+		paths[f.Path] = true
+
+		if f.Size < 0 {
+			return invalid("files[%d].size negativo: %d", i, f.Size)
+		}
+		if f.Mode&^0777 != 0 {
+			return invalid("files[%d].mode inválido: %o", i, f.Mode)
+		}
+		if f.Offset != offset {
+			return invalid("files[%d].offset = %d, esperado %d (arquivos sobrepostos ou com lacunas)", i, f.Offset, offset)
+		}
+		offset += f.Size
+	}
+
+	if offset != m.FileSize {
+		return invalid("arquivos somam %d bytes, file_size = %d", offset, m.FileSize)
+	}
+
+	// Um arquivo não pode ser também diretório de outro ("a" e "a/b")
+	for path := range paths {
+		for dir := parentDir(path); dir != ""; dir = parentDir(dir) {
+			if paths[dir] {
+				return invalid("%s é arquivo e diretório de %s", dir, path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkFileName exige um único componente de caminho, de modo que o download
+// não saia de download_dir
+func checkFileName(name string) error {
+	if err := storage.CheckPath(name); err != nil {
+		return invalid("file_name: %v", err)
+	}
+	if strings.Contains(name, "/") {
+		return invalid("file_name não pode conter diretórios: %s", name)
+	}
+	return nil
+}
+
+// parentDir retorna o diretório de um caminho separado por "/" (vazio na raiz)
+func parentDir(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
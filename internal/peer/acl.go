@@ -0,0 +1,150 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// peerRulePrefix marca entradas que se referem a identidades de peers
+const peerRulePrefix = "peer:"
+
+// AccessList define quais endereços podem se conectar ao servidor.
+// Regras de negação têm prioridade; uma lista de permissão vazia libera todos.
+// Entradas "peer:<id>" valem para identidades autenticadas no handshake.
+type AccessList struct {
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	allowPeers map[string]bool
+	denyPeers  map[string]bool
+}
+
+// NewAccessList cria uma lista de acesso a partir de blocos CIDR e IDs de peers
+// (endereços sem máscara são tratados como um único host)
+func NewAccessList(allow, deny []string) (*AccessList, error) {
+	allowIPs, allowPeers := splitPeerRules(allow)
+	denyIPs, denyPeers := splitPeerRules(deny)
+
+	allowNets, err := parseCIDRs(allowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("erro na lista de permissão: %w", err)
+	}
+
+	denyNets, err := parseCIDRs(denyIPs)
+	if err != nil {
+		return nil, fmt.Errorf("erro na lista de bloqueio: %w", err)
+	}
+
+	return &AccessList{
+		allow:      allowNets,
+		deny:       denyNets,
+		allowPeers: allowPeers,
+		denyPeers:  denyPeers,
+	}, nil
+}
+
+// AllowsPeer verifica se uma identidade autenticada pode se conectar
+func (a *AccessList) AllowsPeer(peerID string) bool {
+	if a == nil {
+		return true
+	}
+
+	if a.denyPeers[peerID] {
+		return false
+	}
+
+	return len(a.allowPeers) == 0 || a.allowPeers[peerID]
+}
+
+// HasPeerRules indica se há regras "peer:<id>", que só valem com handshake
+func (a *AccessList) HasPeerRules() bool {
+	return a != nil && (len(a.allowPeers) > 0 || len(a.denyPeers) > 0)
+}
+
+// splitPeerRules separa entradas de identidade das entradas de IP
+func splitPeerRules(entries []string) ([]string, map[string]bool) {
+	var ips []string
+	peers := make(map[string]bool)
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, peerRulePrefix) {
+			peers[strings.TrimPrefix(entry, peerRulePrefix)] = true
+			continue
+		}
+		ips = append(ips, entry)
+	}
+
+	return ips, peers
+}
+
+// Allows verifica se um IP pode se conectar
+func (a *AccessList) Allows(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.allow) == 0 {
+		return true
+	}
+
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCIDRs converte uma lista de strings em redes
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		// Endereço simples: usa máscara completa
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("endereço inválido: %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("CIDR inválido: %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// remoteIP extrai o IP de um endereço remoto
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+		return net.ParseIP(host)
+	}
+}
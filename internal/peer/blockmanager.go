@@ -2,35 +2,186 @@ package peer
 
 import (
 	"sync"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/bitfield"
 )
 
 // BlockManager gerencia o estado dos blocos de um arquivo de forma thread-safe
 type BlockManager struct {
-	totalBlocks      int
-	availableBlocks  map[int]bool // map[blockID]isAvailable
-	mu               sync.RWMutex
-	downloadComplete bool
+	totalBlocks int
+
+	// Estado de cada bloco em arrays paralelos indexados pelo ID
+	states    []BlockState
+	changedAt []int64  // UnixNano da última transição (0 = nunca mudou)
+	sources   []uint32 // índice em sourceNames (0 = nenhum)
+
+	// Vizinhos internados para não repetir o endereço em cada bloco
+	sourceNames []string
+	sourceIndex map[string]uint32
+
+	availableBlocks    *bitfield.Bitfield // bit ligado = bloco verificado
+	busyBlocks         *bitfield.Bitfield // bit ligado = bloco não pode ser reservado
+	availableCount     int                // popcount mantido incrementalmente
+	stateCounts        [len(blockStateNames)]int
+	firstClaimableHint int // nenhum bloco abaixo deste índice pode ser reservado
+	mu                 sync.RWMutex
+	downloadComplete   bool
+	events             *EventBus
 }
 
 // NewBlockManager cria um novo gerenciador de blocos
 func NewBlockManager(totalBlocks int) *BlockManager {
-	return &BlockManager{
+	bm := &BlockManager{
 		totalBlocks:      totalBlocks,
-		availableBlocks:  make(map[int]bool),
+		states:           make([]BlockState, totalBlocks),
+		changedAt:        make([]int64, totalBlocks),
+		sources:          make([]uint32, totalBlocks),
+		sourceNames:      []string{""},
+		sourceIndex:      make(map[string]uint32),
+		availableBlocks:  bitfield.New(totalBlocks),
+		busyBlocks:       bitfield.New(totalBlocks),
 		downloadComplete: false,
+		events:           NewEventBus(),
+	}
+	bm.stateCounts[BlockMissing] = totalBlocks
+
+	return bm
+}
+
+// Events retorna o barramento onde cliente, servidor e peer publicam eventos
+// sobre estes blocos
+func (bm *BlockManager) Events() *EventBus {
+	return bm.events
+}
+
+// setState aplica uma transição permitida e atualiza os índices auxiliares.
+// source vazio mantém o vizinho registrado anteriormente. Requer bm.mu.
+func (bm *BlockManager) setState(blockID int, to BlockState, source string) bool {
+	if blockID < 0 || blockID >= bm.totalBlocks {
+		return false
+	}
+
+	from := bm.states[blockID]
+	if !canTransition(from, to) {
+		return false
+	}
+
+	bm.states[blockID] = to
+	bm.changedAt[blockID] = time.Now().UnixNano()
+	bm.stateCounts[from]--
+	bm.stateCounts[to]++
+	if source != "" {
+		bm.sources[blockID] = bm.internSource(source)
+	}
+
+	// Disponibilidade acompanha o estado verificado
+	switch {
+	case to == BlockVerified:
+		bm.availableBlocks.Set(blockID)
+		bm.availableCount++
+	case from == BlockVerified:
+		bm.availableBlocks.Clear(blockID)
+		bm.availableCount--
+	}
+	bm.downloadComplete = bm.availableCount == bm.totalBlocks
+
+	// Blocos faltantes ou corrompidos voltam a ser elegíveis para reserva
+	if to.claimable() {
+		bm.busyBlocks.Clear(blockID)
+		if blockID < bm.firstClaimableHint {
+			bm.firstClaimableHint = blockID
+		}
+	} else {
+		bm.busyBlocks.Set(blockID)
 	}
+
+	return true
 }
 
-// MarkBlockAvailable marca um bloco como disponível
+// internSource retorna o índice do vizinho, registrando-o se for novo. Requer bm.mu.
+func (bm *BlockManager) internSource(source string) uint32 {
+	if idx, ok := bm.sourceIndex[source]; ok {
+		return idx
+	}
+
+	idx := uint32(len(bm.sourceNames))
+	bm.sourceNames = append(bm.sourceNames, source)
+	bm.sourceIndex[source] = idx
+	return idx
+}
+
+// MarkBlockAvailable marca um bloco como verificado e disponível
 func (bm *BlockManager) MarkBlockAvailable(blockID int) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	bm.availableBlocks[blockID] = true
+	bm.setState(blockID, BlockVerified, "")
+}
+
+// MarkBlockUnavailable devolve um bloco verificado ao estado faltante
+func (bm *BlockManager) MarkBlockUnavailable(blockID int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.setState(blockID, BlockMissing, "")
+}
+
+// MarkBlockReceived registra que os dados de um bloco reservado chegaram do vizinho
+func (bm *BlockManager) MarkBlockReceived(blockID int, neighbor string) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	return bm.setState(blockID, BlockReceived, neighbor)
+}
+
+// MarkBlockCorrupt registra que um bloco recebido ou gravado não confere com
+// os metadados; o bloco deixa de estar disponível e volta a ser elegível
+func (bm *BlockManager) MarkBlockCorrupt(blockID int) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	return bm.setState(blockID, BlockCorrupt, "")
+}
+
+// ClaimNextMissingBlock reserva para neighbor o menor bloco faltante ou
+// corrompido que ele possui segundo remote (nil = qualquer um) e o marca como
+// solicitado. Retorna -1 se não há bloco elegível.
+func (bm *BlockManager) ClaimNextMissingBlock(remote *bitfield.Bitfield, neighbor string) int {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	var next int
+	if remote == nil {
+		next = bm.busyBlocks.NextClear(bm.firstClaimableHint)
+		if next == -1 {
+			bm.firstClaimableHint = bm.totalBlocks
+		} else {
+			bm.firstClaimableHint = next
+		}
+	} else {
+		next = bm.busyBlocks.NextClearIn(bm.firstClaimableHint, remote)
+	}
+
+	if next == -1 || !bm.setState(next, BlockRequested, neighbor) {
+		return -1
+	}
+
+	return next
+}
+
+// ReleaseBlock devolve à fila um bloco reservado cujo download não terminou
+func (bm *BlockManager) ReleaseBlock(blockID int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if blockID < 0 || blockID >= bm.totalBlocks {
+		return
+	}
 
-	// Verifica se o download está completo
-	if len(bm.availableBlocks) == bm.totalBlocks {
-		bm.downloadComplete = true
+	switch bm.states[blockID] {
+	case BlockRequested, BlockReceived:
+		bm.setState(blockID, BlockMissing, "")
 	}
 }
 
@@ -39,28 +190,101 @@ func (bm *BlockManager) IsBlockAvailable(blockID int) bool {
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	return bm.availableBlocks[blockID]
+	if blockID < 0 || blockID >= bm.totalBlocks {
+		return false
+	}
+
+	return bm.availableBlocks.Test(blockID)
 }
 
-// GetAvailableBlocks retorna lista de IDs dos blocos disponíveis
-func (bm *BlockManager) GetAvailableBlocks() []int {
+// GetBlockState retorna o estado atual de um bloco (BlockMissing se o ID é inválido)
+func (bm *BlockManager) GetBlockState(blockID int) BlockState {
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	blocks := make([]int, 0, len(bm.availableBlocks))
-	for blockID := range bm.availableBlocks {
-		blocks = append(blocks, blockID)
+	if blockID < 0 || blockID >= bm.totalBlocks {
+		return BlockMissing
+	}
+
+	return bm.states[blockID]
+}
+
+// GetBlockStatus retorna estado, horário da última transição e vizinho de origem de um bloco
+func (bm *BlockManager) GetBlockStatus(blockID int) (BlockStatus, bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	if blockID < 0 || blockID >= bm.totalBlocks {
+		return BlockStatus{}, false
+	}
+
+	return bm.statusLocked(blockID), true
+}
+
+// GetBlocksInState retorna o status dos blocos que estão no estado informado
+func (bm *BlockManager) GetBlocksInState(state BlockState) []BlockStatus {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	var blocks []BlockStatus
+	for i, s := range bm.states {
+		if s == state {
+			blocks = append(blocks, bm.statusLocked(i))
+		}
 	}
 
 	return blocks
 }
 
+// GetStateCounts retorna quantos blocos estão em cada estado
+func (bm *BlockManager) GetStateCounts() map[BlockState]int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	counts := make(map[BlockState]int, len(bm.stateCounts))
+	for state, n := range bm.stateCounts {
+		counts[BlockState(state)] = n
+	}
+
+	return counts
+}
+
+// statusLocked monta o BlockStatus de um bloco. Requer bm.mu.
+func (bm *BlockManager) statusLocked(blockID int) BlockStatus {
+	status := BlockStatus{
+		ID:     blockID,
+		State:  bm.states[blockID],
+		Source: bm.sourceNames[bm.sources[blockID]],
+	}
+	if ts := bm.changedAt[blockID]; ts != 0 {
+		status.ChangedAt = time.Unix(0, ts)
+	}
+
+	return status
+}
+
+// GetAvailableBlocks retorna lista de IDs dos blocos disponíveis
+func (bm *BlockManager) GetAvailableBlocks() []int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.availableBlocks.Indices()
+}
+
+// GetAvailabilitySnapshot retorna uma cópia do bitset de blocos disponíveis
+func (bm *BlockManager) GetAvailabilitySnapshot() *bitfield.Bitfield {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.availableBlocks.Clone()
+}
+
 // GetAvailableBlocksCount retorna o número de blocos disponíveis
 func (bm *BlockManager) GetAvailableBlocksCount() int {
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	return len(bm.availableBlocks)
+	return bm.availableCount
 }
 
 // GetMissingBlocksCount retorna o número de blocos faltantes
@@ -68,7 +292,7 @@ func (bm *BlockManager) GetMissingBlocksCount() int {
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	return bm.totalBlocks - len(bm.availableBlocks)
+	return bm.totalBlocks - bm.availableCount
 }
 
 // IsDownloadComplete verifica se todos os blocos foram baixados
@@ -81,17 +305,54 @@ func (bm *BlockManager) IsDownloadComplete() bool {
 
 // GetTotalBlocks retorna o número total de blocos
 func (bm *BlockManager) GetTotalBlocks() int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
 	return bm.totalBlocks
 }
 
+// Grow acrescenta blocos faltantes até totalBlocks (conteúdo ao vivo que
+// cresceu); os blocos existentes mantêm o estado
+func (bm *BlockManager) Grow(totalBlocks int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	added := totalBlocks - bm.totalBlocks
+	if added <= 0 {
+		return
+	}
+
+	bm.states = append(bm.states, make([]BlockState, added)...)
+	bm.changedAt = append(bm.changedAt, make([]int64, added)...)
+	bm.sources = append(bm.sources, make([]uint32, added)...)
+	bm.availableBlocks.Grow(totalBlocks)
+	bm.busyBlocks.Grow(totalBlocks)
+	bm.stateCounts[BlockMissing] += added
+
+	// Dica que apontava para o fim passa a apontar para o primeiro bloco novo
+	bm.firstClaimableHint = min(bm.firstClaimableHint, bm.totalBlocks)
+
+	bm.totalBlocks = totalBlocks
+	bm.downloadComplete = false
+}
+
 // MarkAllBlocksAvailable marca todos os blocos como disponíveis (para seeders)
 func (bm *BlockManager) MarkAllBlocksAvailable() {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	for i := 0; i < bm.totalBlocks; i++ {
-		bm.availableBlocks[i] = true
+	now := time.Now().UnixNano()
+	for i := range bm.states {
+		bm.states[i] = BlockVerified
+		bm.changedAt[i] = now
 	}
+	bm.stateCounts = [len(blockStateNames)]int{}
+	bm.stateCounts[BlockVerified] = bm.totalBlocks
+
+	bm.availableBlocks.SetAll()
+	bm.busyBlocks.SetAll()
+	bm.availableCount = bm.totalBlocks
+	bm.firstClaimableHint = bm.totalBlocks
 	bm.downloadComplete = true
 }
 
@@ -104,19 +365,5 @@ func (bm *BlockManager) GetProgress() float64 {
 		return 0.0
 	}
 
-	return float64(len(bm.availableBlocks)) / float64(bm.totalBlocks)
-}
-
-// GetNextMissingBlock retorna o próximo bloco faltante (menor ID), ou -1 se todos estão disponíveis
-func (bm *BlockManager) GetNextMissingBlock() int {
-	bm.mu.RLock()
-	defer bm.mu.RUnlock()
-
-	for i := 0; i < bm.totalBlocks; i++ {
-		if !bm.availableBlocks[i] {
-			return i
-		}
-	}
-
-	return -1
+	return float64(bm.availableCount) / float64(bm.totalBlocks)
 }
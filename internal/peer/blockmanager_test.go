@@ -0,0 +1,115 @@
+package peer
+
+import (
+	"sync"
+	"testing"
+)
+
+// benchBlocks é o número de blocos usado nos benchmarks (arquivo de 16GB com
+// blocos de 256KB)
+const benchBlocks = 1 << 16
+
+// mapBlockManager reproduz o gerenciador anterior, com a disponibilidade em um
+// map[blockID]bool, para comparação nos benchmarks
+type mapBlockManager struct {
+	totalBlocks     int
+	availableBlocks map[int]bool
+	mu              sync.RWMutex
+}
+
+func newMapBlockManager(totalBlocks int) *mapBlockManager {
+	return &mapBlockManager{totalBlocks: totalBlocks, availableBlocks: make(map[int]bool)}
+}
+
+func (bm *mapBlockManager) MarkBlockAvailable(blockID int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.availableBlocks[blockID] = true
+}
+
+// GetNextMissingBlock percorre os IDs desde o início a cada chamada
+func (bm *mapBlockManager) GetNextMissingBlock() int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	for i := 0; i < bm.totalBlocks; i++ {
+		if !bm.availableBlocks[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func (bm *mapBlockManager) GetAvailableBlocks() []int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	blocks := make([]int, 0, len(bm.availableBlocks))
+	for blockID := range bm.availableBlocks {
+		blocks = append(blocks, blockID)
+	}
+	return blocks
+}
+
+// BenchmarkClaimNextMissingBlock mede a escolha do próximo bloco seguida da
+// marcação como verificado, como faz cada worker do cliente
+func BenchmarkClaimNextMissingBlock(b *testing.B) {
+	b.Run("bitset", func(b *testing.B) {
+		bm := NewBlockManager(benchBlocks)
+		for i := 0; i < b.N; i++ {
+			blockID := bm.ClaimNextMissingBlock(nil, "vizinho")
+			if blockID == -1 {
+				b.StopTimer()
+				bm = NewBlockManager(benchBlocks)
+				b.StartTimer()
+				blockID = bm.ClaimNextMissingBlock(nil, "vizinho")
+			}
+			bm.MarkBlockAvailable(blockID)
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		bm := newMapBlockManager(benchBlocks)
+		for i := 0; i < b.N; i++ {
+			blockID := bm.GetNextMissingBlock()
+			if blockID == -1 {
+				b.StopTimer()
+				bm = newMapBlockManager(benchBlocks)
+				b.StartTimer()
+				blockID = bm.GetNextMissingBlock()
+			}
+			bm.MarkBlockAvailable(blockID)
+		}
+	})
+}
+
+// BenchmarkGetAvailableBlocks mede a listagem dos blocos disponíveis com
+// metade do arquivo baixado (blocos pares)
+func BenchmarkGetAvailableBlocks(b *testing.B) {
+	b.Run("bitset", func(b *testing.B) {
+		bm := NewBlockManager(benchBlocks)
+		for blockID := 0; blockID < benchBlocks; blockID += 2 {
+			bm.MarkBlockAvailable(blockID)
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bm.GetAvailableBlocks()
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		bm := newMapBlockManager(benchBlocks)
+		for blockID := 0; blockID < benchBlocks; blockID += 2 {
+			bm.MarkBlockAvailable(blockID)
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bm.GetAvailableBlocks()
+		}
+	})
+}
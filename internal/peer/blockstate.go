@@ -0,0 +1,62 @@
+package peer
+
+import "time"
+
+// BlockState representa a etapa do ciclo de vida de um bloco
+type BlockState uint8
+
+const (
+	BlockMissing   BlockState = iota // nunca obtido (ou devolvido à fila)
+	BlockRequested                   // reservado por um worker e solicitado a um vizinho
+	BlockReceived                    // dados recebidos, aguardando verificação e escrita
+	BlockVerified                    // conferido com os metadados e gravado no disco
+	BlockCorrupt                     // falhou na verificação; elegível para novo download
+)
+
+// blockStateNames mapeia cada estado para o nome usado em logs e estatísticas
+var blockStateNames = [...]string{
+	BlockMissing:   "missing",
+	BlockRequested: "requested",
+	BlockReceived:  "received",
+	BlockVerified:  "verified",
+	BlockCorrupt:   "corrupt",
+}
+
+func (s BlockState) String() string {
+	if int(s) < len(blockStateNames) {
+		return blockStateNames[s]
+	}
+	return "unknown"
+}
+
+// blockTransitions lista, para cada estado, os estados de destino permitidos
+var blockTransitions = [...][]BlockState{
+	BlockMissing:   {BlockRequested, BlockVerified},
+	BlockRequested: {BlockMissing, BlockReceived, BlockVerified},
+	BlockReceived:  {BlockMissing, BlockVerified, BlockCorrupt},
+	BlockVerified:  {BlockMissing, BlockCorrupt},
+	BlockCorrupt:   {BlockMissing, BlockRequested, BlockVerified},
+}
+
+// canTransition verifica se a mudança de estado é permitida
+func canTransition(from, to BlockState) bool {
+	for _, allowed := range blockTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// claimable indica se um bloco nesse estado pode ser reservado para download
+func (s BlockState) claimable() bool {
+	return s == BlockMissing || s == BlockCorrupt
+}
+
+// BlockStatus descreve o estado atual de um bloco
+type BlockStatus struct {
+	ID        int
+	State     BlockState
+	ChangedAt time.Time // zero se o bloco nunca mudou de estado
+	Source    string    // vizinho que forneceu (ou está fornecendo) o bloco
+}
@@ -1,15 +1,36 @@
 package peer
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/zatta/tp2-p2p/internal/bitfield"
 	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/identity"
 	"github.com/zatta/tp2-p2p/internal/metadata"
 	"github.com/zatta/tp2-p2p/internal/protocol"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+const (
+	// busyRetryDelay é a espera antes de reconectar a um servidor lotado
+	busyRetryDelay = 2 * time.Second
+
+	// availabilityRefreshDelay é a espera antes de reconsultar um vizinho que
+	// não possui nenhum dos blocos faltantes
+	availabilityRefreshDelay = 1 * time.Second
+
+	// maxLiveBlocksAhead limita quantos blocos além dos conhecidos localmente
+	// um vizinho com conteúdo ao vivo pode anunciar, para que um PEER_INFO
+	// forjado não force a alocação de um bitfield arbitrário
+	maxLiveBlocksAhead = 1 << 16
 )
 
 // NeighborInfo representa informações de um peer vizinho
@@ -17,66 +38,292 @@ type NeighborInfo struct {
 	Address string // formato: "ip:port"
 }
 
+// ClientOptions contém parâmetros opcionais do cliente
+type ClientOptions struct {
+	TLSConfig *tls.Config // TLS com autenticação mútua (nil = TCP puro)
+
+	// Identity autentica o cliente e exige que os vizinhos provem sua identidade
+	Identity *identity.Identity
+
+	// SwarmKey (ver DeriveSwarmKey) exige que os vizinhos conheçam o segredo do
+	// swarm; EncryptStream cifra o tráfego após o handshake
+	SwarmKey      []byte
+	EncryptStream bool
+}
+
 // Client representa o cliente que baixa blocos de outros peers
 type Client struct {
 	neighbors    []NeighborInfo
+	options      ClientOptions
 	blockManager *BlockManager
 	metadata     *metadata.Metadata
-	filePath     string
+	storage      storage.Storage
 	logger       *log.Logger
 	stopChan     chan struct{}
-	wg           sync.WaitGroup
+
+	mu             sync.Mutex
+	idle           *sync.Cond // sinalizado quando activeWorkers chega a zero (usa c.mu)
+	activeWorkers  int        // goroutines de download em execução
+	requeuePending bool       // re-download solicitado enquanto workers encerravam
+	detached       bool       // download abandonado sem workers (ver Detach)
+	stopped        bool
+	conns          map[net.Conn]struct{} // conexões abertas com vizinhos
+	verifier       *BlockVerifier
+	infoHash       string // swarm pedido aos vizinhos (vazio = não informado)
 }
 
 // NewClient cria um novo cliente
-func NewClient(neighbors []NeighborInfo, blockManager *BlockManager, meta *metadata.Metadata, filePath string, logger *log.Logger) *Client {
-	return &Client{
+func NewClient(neighbors []NeighborInfo, blockManager *BlockManager, meta *metadata.Metadata, store storage.Storage, logger *log.Logger, options ClientOptions) *Client {
+	c := &Client{
 		neighbors:    neighbors,
+		options:      options,
 		blockManager: blockManager,
 		metadata:     meta,
-		filePath:     filePath,
+		storage:      store,
 		logger:       logger,
 		stopChan:     make(chan struct{}),
+		conns:        make(map[net.Conn]struct{}),
+		verifier:     &BlockVerifier{meta: meta},
 	}
+	c.idle = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// SetBlockVerifier substitui o verificador de blocos (obrigatório no modo Merkle)
+func (c *Client) SetBlockVerifier(v *BlockVerifier) {
+	c.verifier = v
+}
+
+// SetInfoHash define o swarm pedido aos vizinhos no handshake e no REQUEST_INFO
+func (c *Client) SetInfoHash(infoHash string) {
+	c.infoHash = infoHash
 }
 
 // Start inicia o processo de download
 func (c *Client) Start() {
 	c.logger.Printf("[CLIENT] Iniciando download de %d vizinhos", len(c.neighbors))
 
-	// Inicia uma goroutine para cada vizinho
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startWorkersLocked()
+}
+
+// startWorkersLocked inicia uma goroutine para cada vizinho (requer c.mu)
+func (c *Client) startWorkersLocked() {
+	c.detached = false
 	for _, neighbor := range c.neighbors {
-		c.wg.Add(1)
+		c.activeWorkers++
 		go c.downloadFromNeighbor(neighbor)
 	}
 }
 
+// RequeueBlock agenda o re-download de um bloco invalidado localmente.
+// O bloco já deve ter sido marcado como corrompido no BlockManager; se não
+// houver workers ativos, eles são reiniciados para buscá-lo novamente.
+// Retorna true se o download havia sido abandonado (ver Detach) e precisa
+// voltar a ser acompanhado.
+func (c *Client) RequeueBlock(blockID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return false
+	}
+
+	c.logger.Printf("[CLIENT] Bloco %d reagendado para download", blockID)
+	return c.restartLocked()
+}
+
+// Resume retoma o download após o conteúdo ao vivo ganhar blocos novos.
+// Retorna true nas mesmas condições de RequeueBlock.
+func (c *Client) Resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return false
+	}
+
+	return c.restartLocked()
+}
+
+// Detach é chamado por quem aguardava o download ao desistir dele com blocos
+// faltantes. Retorna false se os workers já foram reiniciados e o download
+// continua; senão o próximo RequeueBlock ou Resume avisa que ninguém o acompanha.
+func (c *Client) Detach() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.activeWorkers > 0 {
+		return false
+	}
+
+	c.detached = true
+	return true
+}
+
+// restartLocked garante que haverá workers para buscar os blocos faltantes e
+// retorna true se o download estava abandonado (requer c.mu)
+func (c *Client) restartLocked() bool {
+	if c.activeWorkers > 0 {
+		// Workers em execução reservam o bloco via ClaimNextMissingBlock, mas podem
+		// estar encerrando; o último a sair reinicia o download
+		c.requeuePending = true
+		return false
+	}
+
+	detached := c.detached
+	c.startWorkersLocked()
+	return detached
+}
+
+// workerDone registra o fim de um worker e reinicia o download se houver
+// blocos reagendados; sem reinício, acorda quem espera em waitIdle
+func (c *Client) workerDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.activeWorkers--
+	if c.activeWorkers > 0 {
+		return
+	}
+
+	if c.requeuePending {
+		c.requeuePending = false
+		if !c.stopped && !c.blockManager.IsDownloadComplete() {
+			c.startWorkersLocked()
+		}
+	}
+
+	if c.activeWorkers == 0 {
+		c.idle.Broadcast()
+	}
+}
+
+// waitIdle aguarda até não haver workers em execução. Reinícios feitos sob
+// c.mu antes de o último worker sair mantêm a espera.
+func (c *Client) waitIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.activeWorkers > 0 {
+		c.idle.Wait()
+	}
+}
+
 // Wait aguarda todas as goroutines de download terminarem
 func (c *Client) Wait() {
-	c.wg.Wait()
+	c.waitIdle()
 	c.logger.Printf("[CLIENT] Todos os downloads concluídos")
 }
 
-// Stop para o cliente
-func (c *Client) Stop() {
+// Stop para o cliente e aguarda os workers terminarem o bloco em andamento,
+// de modo que nenhum bloco parcialmente escrito seja marcado como disponível.
+// Se o prazo de ctx expirar, as conexões são fechadas à força.
+func (c *Client) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
 	close(c.stopChan)
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.waitIdle()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Fecha conexões para desbloquear leituras pendentes
+		c.mu.Lock()
+		for conn := range c.conns {
+			conn.Close()
+		}
+		c.mu.Unlock()
+		<-done
+
+		return ctx.Err()
+	}
+}
+
+// sleep aguarda a duração informada; retorna false se o cliente foi parado
+func (c *Client) sleep(d time.Duration) bool {
+	select {
+	case <-c.stopChan:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// connect conecta ao vizinho e registra a conexão
+func (c *Client) connect(address string) (net.Conn, error) {
+	conn, err := c.connectWithRetry(address, 3, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		conn.Close()
+		return nil, fmt.Errorf("cliente parado")
+	}
+	c.conns[conn] = struct{}{}
+
+	return conn, nil
+}
+
+// emit publica um evento no barramento do block manager
+func (c *Client) emit(event Event) {
+	c.blockManager.Events().Publish(event)
+}
+
+// disconnect fecha a conexão e remove do registro
+func (c *Client) disconnect(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+
+	conn.Close()
 }
 
 // downloadFromNeighbor baixa blocos de um vizinho específico
 func (c *Client) downloadFromNeighbor(neighbor NeighborInfo) {
-	defer c.wg.Done()
+	defer c.workerDone()
 
 	c.logger.Printf("[CLIENT] Conectando ao vizinho %s", neighbor.Address)
 
 	// Tenta conectar com retry
-	conn, err := c.connectWithRetry(neighbor.Address, 3, 2*time.Second)
+	conn, err := c.connect(neighbor.Address)
 	if err != nil {
 		c.logger.Printf("[CLIENT] Falha ao conectar com %s: %v", neighbor.Address, err)
 		return
 	}
-	defer conn.Close()
+	defer func() {
+		if conn != nil {
+			c.disconnect(conn)
+			c.emit(Event{Type: EventNeighborDisconnected, BlockID: -1, Neighbor: neighbor.Address})
+		}
+	}()
 
 	c.logger.Printf("[CLIENT] Conectado a %s", neighbor.Address)
+	c.emit(Event{Type: EventNeighborConnected, BlockID: -1, Neighbor: neighbor.Address})
+
+	// Blocos que o vizinho possui (nil = desconhecido, tenta qualquer um)
+	remote, err := c.requestAvailability(conn, neighbor.Address)
+	if isUnknownSwarm(err) {
+		c.logger.Printf("[CLIENT] Desistindo de %s: vizinho não serve este conteúdo", neighbor.Address)
+		return
+	}
 
 	// Loop de download até ter todos os blocos ou ser parado
 	for {
@@ -92,30 +339,65 @@ func (c *Client) downloadFromNeighbor(neighbor NeighborInfo) {
 			return
 		}
 
-		// Pega próximo bloco faltante
-		blockID := c.blockManager.GetNextMissingBlock()
+		// Reserva o próximo bloco faltante que o vizinho possui
+		blockID := c.blockManager.ClaimNextMissingBlock(remote, neighbor.Address)
 		if blockID == -1 {
-			// Não há blocos faltantes
-			return
+			// Faltantes estão reservados por outros workers ou o vizinho ainda
+			// não os tem: aguarda e consulta de novo
+			if !c.sleep(availabilityRefreshDelay) {
+				return
+			}
+			if remote != nil {
+				remote, _ = c.requestAvailability(conn, neighbor.Address)
+			}
+			continue
 		}
 
 		// Tenta baixar o bloco
 		if err := c.downloadBlock(conn, neighbor.Address, blockID); err != nil {
 			c.logger.Printf("[CLIENT] Erro ao baixar bloco %d de %s: %v", blockID, neighbor.Address, err)
 
+			// Devolve a reserva (blocos corrompidos já voltaram à fila)
+			c.blockManager.ReleaseBlock(blockID)
+
+			// Vizinho recusou nossa identidade: novas tentativas não adiantam
+			if isAuthError(err) {
+				c.logger.Printf("[CLIENT] Desistindo de %s: autenticação recusada", neighbor.Address)
+				return
+			}
+
 			// Se erro de conexão, tenta reconectar
 			if isConnectionError(err) {
 				c.logger.Printf("[CLIENT] Tentando reconectar com %s", neighbor.Address)
-				conn.Close()
-				conn, err = c.connectWithRetry(neighbor.Address, 3, 2*time.Second)
+				c.disconnect(conn)
+				c.emit(Event{Type: EventNeighborDisconnected, BlockID: -1, Neighbor: neighbor.Address, Err: err})
+
+				// Servidor lotado: aguarda antes de ocupar outra vaga
+				if isServerBusy(err) && !c.sleep(busyRetryDelay) {
+					conn = nil
+					return
+				}
+
+				newConn, err := c.connect(neighbor.Address)
 				if err != nil {
 					c.logger.Printf("[CLIENT] Falha ao reconectar com %s: %v", neighbor.Address, err)
+					conn = nil
 					return
 				}
+				conn = newConn
+				c.emit(Event{Type: EventNeighborConnected, BlockID: -1, Neighbor: neighbor.Address})
+				remote, _ = c.requestAvailability(conn, neighbor.Address)
+			}
+
+			// Visão do vizinho estava desatualizada (ex: bloco corrompido no disco dele)
+			if isBlockUnavailable(err) && remote != nil {
+				remote.Clear(blockID)
 			}
 
 			// Pequena pausa antes de tentar outro bloco
-			time.Sleep(100 * time.Millisecond)
+			if !c.sleep(100 * time.Millisecond) {
+				return
+			}
 			continue
 		}
 
@@ -125,6 +407,60 @@ func (c *Client) downloadFromNeighbor(neighbor NeighborInfo) {
 	}
 }
 
+// requestAvailability consulta quais blocos o vizinho possui, anunciando as
+// codificações compactas suportadas. Se a consulta falhar, registra o erro e
+// retorna nil junto com ele.
+func (c *Client) requestAvailability(conn net.Conn, neighborAddr string) (*bitfield.Bitfield, error) {
+	remote, err := c.fetchAvailability(conn)
+	if err != nil {
+		c.logger.Printf("[CLIENT] Erro ao consultar blocos de %s: %v", neighborAddr, err)
+		return nil, err
+	}
+
+	c.logger.Printf("[CLIENT] Vizinho %s possui %d/%d blocos", neighborAddr, remote.Count(), remote.Len())
+	return remote, nil
+}
+
+// fetchAvailability envia REQUEST_INFO e decodifica o PEER_INFO recebido
+func (c *Client) fetchAvailability(conn net.Conn) (*bitfield.Bitfield, error) {
+	request := protocol.NewRequestInfo(protocol.SupportedAvailabilityEncodings...)
+	request.InfoHash = c.infoHash
+	if err := protocol.SendMessage(conn, request); err != nil {
+		return nil, fmt.Errorf("erro ao enviar REQUEST_INFO: %w", err)
+	}
+
+	msgData, err := protocol.ReceiveMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao receber resposta: %w", err)
+	}
+
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao parsear resposta: %w", err)
+	}
+
+	switch m := msg.(type) {
+	case *protocol.PeerInfoMsg:
+		// Conteúdo ao vivo: o vizinho pode estar em outra extensão; blocos
+		// além dos conhecidos localmente são ignorados na reserva
+		total := c.blockManager.GetTotalBlocks()
+		if !c.metadata.IsLive() {
+			if m.TotalBlocks != total {
+				return nil, fmt.Errorf("vizinho anuncia %d blocos, esperado %d", m.TotalBlocks, total)
+			}
+		} else if m.TotalBlocks > total+maxLiveBlocksAhead {
+			return nil, fmt.Errorf("vizinho anuncia %d blocos, mais de %d além dos %d conhecidos", m.TotalBlocks, maxLiveBlocksAhead, total)
+		}
+		return m.Bitfield()
+
+	case *protocol.ErrorMsg:
+		return nil, fmt.Errorf("erro do servidor: %w", m)
+
+	default:
+		return nil, fmt.Errorf("tipo de mensagem inesperado: %T", msg)
+	}
+}
+
 // downloadBlock baixa um bloco específico
 func (c *Client) downloadBlock(conn net.Conn, neighborAddr string, blockID int) error {
 	// Envia requisição do bloco
@@ -148,33 +484,42 @@ func (c *Client) downloadBlock(conn net.Conn, neighborAddr string, blockID int)
 	// Verifica tipo de mensagem
 	switch m := msg.(type) {
 	case *protocol.BlockDataMsg:
-		// Valida checksum
-		if !checksum.ValidateBlockChecksum(m.Data, m.Checksum) {
-			return fmt.Errorf("checksum inválido para bloco %d", blockID)
+		if m.BlockID != blockID {
+			return fmt.Errorf("bloco %d recebido no lugar do bloco %d", m.BlockID, blockID)
 		}
+		c.blockManager.MarkBlockReceived(blockID, neighborAddr)
 
-		// Valida com metadados
-		expectedBlock, err := c.metadata.GetBlock(blockID)
-		if err != nil {
-			return fmt.Errorf("erro ao obter metadados: %w", err)
+		// Valida checksum
+		if !checksum.ValidateBlockChecksum(m.Data, m.Checksum) {
+			err := fmt.Errorf("checksum inválido para bloco %d", blockID)
+			c.blockManager.MarkBlockCorrupt(blockID)
+			c.emit(Event{Type: EventBlockFailed, BlockID: blockID, Neighbor: neighborAddr, Err: err})
+			return err
 		}
 
-		if m.Checksum != expectedBlock.Hash {
-			return fmt.Errorf("checksum não corresponde aos metadados")
+		// Valida com metadados (hash do bloco ou prova de Merkle)
+		if err := c.verifier.VerifyReceived(blockID, m.Data, m.Proof); err != nil {
+			if !errors.Is(err, errBlockMismatch) {
+				return err
+			}
+			c.blockManager.MarkBlockCorrupt(blockID)
+			c.emit(Event{Type: EventBlockFailed, BlockID: blockID, Neighbor: neighborAddr, Err: err})
+			return err
 		}
 
 		// Escreve bloco no arquivo
-		if err := checksum.WriteBlockToFile(c.filePath, blockID, c.metadata.BlockSize, m.Data); err != nil {
+		if err := c.storage.WriteBlock(blockID, m.Data); err != nil {
 			return fmt.Errorf("erro ao escrever bloco: %w", err)
 		}
 
 		// Marca bloco como disponível
 		c.blockManager.MarkBlockAvailable(blockID)
+		c.emit(Event{Type: EventBlockVerified, BlockID: blockID, Neighbor: neighborAddr})
 
 		return nil
 
 	case *protocol.ErrorMsg:
-		return fmt.Errorf("erro do servidor: %s", m.Message)
+		return fmt.Errorf("erro do servidor: %w", m)
 
 	default:
 		return fmt.Errorf("tipo de mensagem inesperado: %T", msg)
@@ -187,27 +532,125 @@ func (c *Client) connectWithRetry(address string, maxRetries int, retryDelay tim
 	var err error
 
 	for i := 0; i < maxRetries; i++ {
-		conn, err = net.DialTimeout("tcp", address, 5*time.Second)
+		conn, err = c.dial(address)
 		if err == nil {
 			return conn, nil
 		}
 
+		// Vizinho serve outro conteúdo: novas tentativas não adiantam
+		if isUnknownSwarm(err) {
+			return nil, err
+		}
+
 		if i < maxRetries-1 {
 			c.logger.Printf("[CLIENT] Falha ao conectar com %s (tentativa %d/%d): %v. Tentando novamente...",
 				address, i+1, maxRetries, err)
-			time.Sleep(retryDelay)
+			if !c.sleep(retryDelay) {
+				return nil, fmt.Errorf("cliente parado")
+			}
 		}
 	}
 
 	return nil, fmt.Errorf("falha após %d tentativas: %w", maxRetries, err)
 }
 
+// dial abre uma conexão com o vizinho (TLS se configurado) e conduz o
+// handshake de identidade quando o cliente possui uma
+func (c *Client) dial(address string) (net.Conn, error) {
+	conn, peerID, err := dialNeighbor(address, c.options, c.infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if peerID != "" {
+		c.logger.Printf("[CLIENT] Vizinho %s autenticado como %s", address, peerID)
+	}
+
+	return conn, nil
+}
+
+// dialNeighbor conecta ao vizinho com as opções do cliente; retorna o stream
+// e o ID verificado do vizinho, se houve handshake de identidade
+func dialNeighbor(address string, options ClientOptions, infoHash string) (net.Conn, string, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if options.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, options.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	cfg := handshakeConfig{
+		identity: options.Identity,
+		swarmKey: options.SwarmKey,
+		encrypt:  options.EncryptStream,
+		infoHash: infoHash,
+	}
+	if !cfg.enabled() {
+		return conn, "", nil
+	}
+
+	stream, peerID, err := clientHandshake(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("falha no handshake: %w", err)
+	}
+
+	return stream, peerID, nil
+}
+
 // isConnectionError verifica se é um erro de conexão
 func isConnectionError(err error) bool {
 	if err == nil {
 		return false
 	}
+
+	// Servidor lotado fecha a conexão após o aviso
+	if isServerBusy(err) {
+		return true
+	}
+
 	// Verifica tipos comuns de erros de conexão
-	_, isNetErr := err.(net.Error)
-	return isNetErr
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isServerBusy verifica se o servidor recusou a conexão por estar lotado
+func isServerBusy(err error) bool {
+	var errMsg *protocol.ErrorMsg
+	return errors.As(err, &errMsg) && errMsg.Code == protocol.ErrCodeBusy
+}
+
+// isBlockUnavailable verifica se o servidor não possui o bloco solicitado
+func isBlockUnavailable(err error) bool {
+	var errMsg *protocol.ErrorMsg
+	return errors.As(err, &errMsg) && errMsg.Code == protocol.ErrCodeBlockUnavailable
+}
+
+// isAuthError verifica se o servidor recusou a conexão por falta de autenticação
+func isAuthError(err error) bool {
+	var errMsg *protocol.ErrorMsg
+	if !errors.As(err, &errMsg) {
+		return false
+	}
+	return errMsg.Code == protocol.ErrCodeAuthRequired || errMsg.Code == protocol.ErrCodeAuthFailed
+}
+
+// isUnknownSwarm verifica se o vizinho recusou o info hash pedido
+func isUnknownSwarm(err error) bool {
+	var errMsg *protocol.ErrorMsg
+	return errors.As(err, &errMsg) && errMsg.Code == protocol.ErrCodeUnknownSwarm
+}
+
+// isTimeout verifica se o erro foi causado por um prazo de leitura/escrita
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
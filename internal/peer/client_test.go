@@ -0,0 +1,59 @@
+package peer
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/zatta/tp2-p2p/internal/bitfield"
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+// fetchPeerInfo responde ao REQUEST_INFO do cliente com response e retorna
+// o resultado de fetchAvailability
+func fetchPeerInfo(t *testing.T, c *Client, response *protocol.PeerInfoMsg) (*bitfield.Bitfield, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		if _, err := protocol.ReceiveMessage(serverConn); err != nil {
+			return
+		}
+		protocol.SendMessage(serverConn, response)
+	}()
+
+	return c.fetchAvailability(clientConn)
+}
+
+func TestFetchAvailabilityBoundsLiveTotal(t *testing.T) {
+	const local = 8
+	meta := &metadata.Metadata{TotalBlocks: local, Live: &metadata.LiveInfo{}}
+	c := NewClient(nil, NewBlockManager(local), meta, nil, log.New(io.Discard, "", 0), ClientOptions{})
+
+	ahead := bitfield.New(local + 4)
+	ahead.SetAll()
+	remote, err := fetchPeerInfo(t, c, &protocol.PeerInfoMsg{
+		Type:         protocol.MsgTypePeerInfo,
+		TotalBlocks:  ahead.Len(),
+		Encoding:     protocol.AvailabilityRLE,
+		Availability: ahead.EncodeRuns(),
+	})
+	if err != nil || remote.Len() != ahead.Len() {
+		t.Fatalf("vizinho em extensão posterior recusado: %v", err)
+	}
+
+	// Total forjado não pode chegar à decodificação (alocaria 2^40 bits)
+	_, err = fetchPeerInfo(t, c, &protocol.PeerInfoMsg{
+		Type:        protocol.MsgTypePeerInfo,
+		TotalBlocks: 1 << 40,
+		Encoding:    protocol.AvailabilityRLE,
+	})
+	if err == nil {
+		t.Fatal("PEER_INFO com total de blocos desmedido foi aceito")
+	}
+}
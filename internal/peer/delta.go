@@ -0,0 +1,75 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+// deltaResult resume o que foi aproveitado da versão anterior do conteúdo
+type deltaResult struct {
+	blocks int
+	bytes  int64
+}
+
+// reuseBaseBlocks copia da versão anterior do conteúdo (basePath, descrita por
+// baseMeta) os blocos cujo hash também aparece nos novos metadados, marcando-os
+// como disponíveis antes do download. Cada bloco copiado é conferido com os
+// novos metadados, de modo que uma base alterada só custa o bloco divergente.
+func reuseBaseBlocks(meta, baseMeta *metadata.Metadata, basePath string, store storage.Storage,
+	blockManager *BlockManager, verifier *BlockVerifier, logger *log.Logger) (deltaResult, error) {
+	var result deltaResult
+
+	if meta.IsMerkle() || baseMeta.IsMerkle() {
+		return result, fmt.Errorf("reaproveitamento exige metadados com a lista de blocos (sem -merkle)")
+	}
+
+	baseStore, err := baseMeta.OpenStorage(basePath)
+	if err != nil {
+		return result, fmt.Errorf("erro ao abrir versão anterior: %w", err)
+	}
+
+	// Posição de cada hash na versão anterior
+	index := make(map[string]int, len(baseMeta.Blocks))
+	for _, block := range baseMeta.Blocks {
+		if _, ok := index[block.Hash]; !ok {
+			index[block.Hash] = block.ID
+		}
+	}
+
+	for _, block := range meta.Blocks {
+		baseID, ok := index[block.Hash]
+		if !ok {
+			continue
+		}
+
+		data, err := baseStore.ReadBlock(baseID)
+		if err == nil {
+			err = verifier.VerifyLocal(block.ID, data)
+		}
+		if err != nil {
+			logger.Printf("[PEER] Bloco %d da versão anterior descartado: %v", block.ID, err)
+			continue
+		}
+
+		if err := store.WriteBlock(block.ID, data); err != nil {
+			return result, fmt.Errorf("erro ao copiar bloco %d: %w", block.ID, err)
+		}
+		blockManager.MarkBlockAvailable(block.ID)
+
+		result.blocks++
+		result.bytes += int64(len(data))
+	}
+
+	return result, nil
+}
+
+// samePath verifica se dois caminhos apontam para o mesmo lugar
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,161 @@
+package peer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifica o tipo de um evento do peer
+type EventType string
+
+const (
+	EventBlockVerified        EventType = "block_verified"        // bloco recebido e validado
+	EventBlockFailed          EventType = "block_failed"          // bloco recebido ou lido do disco não confere
+	EventNeighborConnected    EventType = "neighbor_connected"    // conexão com vizinho estabelecida
+	EventNeighborDisconnected EventType = "neighbor_disconnected" // conexão com vizinho encerrada
+	EventDownloadComplete     EventType = "download_complete"     // todos os blocos baixados e arquivo validado
+	EventValidationFailed     EventType = "validation_failed"     // arquivo completo não confere com os metadados
+	EventStateChanged         EventType = "state_changed"         // peer mudou de estado (ver PeerState)
+	EventContentExtended      EventType = "content_extended"      // conteúdo ao vivo recebeu blocos novos
+)
+
+// Event descreve algo observável no peer. Campos que não se aplicam ao tipo
+// ficam vazios (BlockID = -1).
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	BlockID  int
+	Neighbor string // endereço do vizinho envolvido, se houver
+	Err      error  // causa de falhas
+	State    PeerState
+}
+
+// EventBus distribui eventos para assinantes sem bloquear quem publica
+type EventBus struct {
+	mu      sync.RWMutex
+	subs    map[int]*subscription
+	nextID  int
+	closed  bool
+	dropped atomic.Int64
+}
+
+// subscription é um assinante por canal (ch) ou callback (fn)
+type subscription struct {
+	ch chan Event
+	fn func(Event)
+}
+
+// NewEventBus cria um barramento de eventos vazio
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[int]*subscription),
+	}
+}
+
+// Subscribe retorna um canal com capacidade buffer que recebe os eventos
+// publicados e uma função que cancela a assinatura (fechando o canal).
+// Eventos que não cabem no buffer são descartados e contados em Dropped.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	id, ok := b.add(&subscription{ch: ch})
+	if !ok {
+		close(ch)
+		return ch, func() {}
+	}
+
+	return ch, func() { b.remove(id) }
+}
+
+// SubscribeFunc registra fn para cada evento publicado e retorna a função de
+// cancelamento. fn roda na goroutine que publica, deve retornar rápido e não
+// pode assinar ou cancelar assinaturas no mesmo barramento.
+func (b *EventBus) SubscribeFunc(fn func(Event)) func() {
+	id, ok := b.add(&subscription{fn: fn})
+	if !ok {
+		return func() {}
+	}
+
+	return func() { b.remove(id) }
+}
+
+// Publish entrega o evento a todos os assinantes
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	for _, sub := range b.subs {
+		if sub.fn != nil {
+			sub.fn(event)
+			continue
+		}
+
+		select {
+		case sub.ch <- event:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped retorna quantos eventos foram descartados por canais cheios
+func (b *EventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// Close cancela todas as assinaturas; publicações posteriores são ignoradas
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for id, sub := range b.subs {
+		if sub.ch != nil {
+			close(sub.ch)
+		}
+		delete(b.subs, id)
+	}
+}
+
+// add registra o assinante, a menos que o barramento já esteja fechado
+func (b *EventBus) add(sub *subscription) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, false
+	}
+
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	return id, true
+}
+
+// remove cancela uma assinatura, fechando seu canal
+func (b *EventBus) remove(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subs[id]
+	if !ok {
+		return
+	}
+
+	if sub.ch != nil {
+		close(sub.ch)
+	}
+	delete(b.subs, id)
+}
@@ -0,0 +1,302 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/identity"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+// Parâmetros do handshake de autenticação
+const (
+	handshakeContext   = "p2psd-handshake-v1"
+	handshakeNonceSize = 32
+	handshakeTimeout   = 10 * time.Second
+)
+
+// errUnknownSwarm indica que o cliente pediu um conteúdo que não é servido aqui
+var errUnknownSwarm = errors.New("swarm desconhecido")
+
+// handshakeConfig reúne as credenciais usadas por um lado do handshake
+type handshakeConfig struct {
+	identity *identity.Identity // Prova de identidade Ed25519 (nil = anônimo)
+	swarmKey []byte             // Chave pré-compartilhada do swarm (nil = desabilitado)
+	encrypt  bool               // Cifra o stream após o handshake (requer swarmKey)
+	infoHash string             // Swarm pedido pelo cliente (vazio = não informado)
+}
+
+// enabled indica se o handshake é obrigatório para este lado
+func (h handshakeConfig) enabled() bool {
+	return h.identity != nil || h.swarmKey != nil
+}
+
+// publicKey retorna a chave pública da identidade (ou nil)
+func (h handshakeConfig) publicKey() []byte {
+	if h.identity == nil {
+		return nil
+	}
+	return h.identity.PublicKey
+}
+
+// peerID retorna o ID da identidade (ou vazio)
+func (h handshakeConfig) peerID() string {
+	if h.identity == nil {
+		return ""
+	}
+	return h.identity.PeerID()
+}
+
+// DeriveSwarmKey converte o segredo configurado em uma chave de 32 bytes
+func DeriveSwarmKey(secret string) []byte {
+	key := sha256.Sum256([]byte("p2psd-swarm-key\n" + secret))
+	return key[:]
+}
+
+// handshakeTranscript monta os bytes assinados (ou autenticados via HMAC) por
+// um dos lados do handshake. Inclui o swarm, os dois nonces e as duas chaves,
+// de modo que uma prova não pode ser reaproveitada em outra conexão ou outro
+// swarm nem atribuída ao outro papel.
+func handshakeTranscript(role, infoHash string, clientNonce, serverNonce, clientKey, serverKey []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(handshakeContext)
+
+	for _, part := range [][]byte{[]byte(role), []byte(infoHash), clientNonce, serverNonce, clientKey, serverKey} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(part)))
+		buf.Write(part)
+	}
+
+	return buf.Bytes()
+}
+
+// swarmMAC calcula a prova de posse da chave do swarm
+func swarmMAC(key, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// randomNonce gera um nonce aleatório para o handshake
+func randomNonce() ([]byte, error) {
+	nonce := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("erro ao gerar nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// verifyPeerKey confere se o ID anunciado corresponde à chave pública
+func verifyPeerKey(peerID string, publicKey []byte) error {
+	if len(publicKey) == 0 {
+		return fmt.Errorf("peer não apresentou chave pública")
+	}
+	if identity.PeerIDFromPublicKey(publicKey) != peerID {
+		return fmt.Errorf("ID %q não corresponde à chave pública", peerID)
+	}
+	return nil
+}
+
+// receiveHandshakeMessage recebe e interpreta uma mensagem durante o handshake
+func receiveHandshakeMessage(conn net.Conn) (protocol.Message, error) {
+	msgData, err := protocol.ReceiveMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao parsear mensagem: %w", err)
+	}
+
+	// Erros do outro lado interrompem o handshake
+	if errMsg, ok := msg.(*protocol.ErrorMsg); ok {
+		return nil, fmt.Errorf("handshake recusado: %w", errMsg)
+	}
+
+	return msg, nil
+}
+
+// clientHandshake autentica a conexão do lado do cliente. Retorna o stream a
+// ser usado (cifrado, se negociado) e o ID verificado do servidor, se houver.
+func clientHandshake(conn net.Conn, cfg handshakeConfig) (net.Conn, string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	clientNonce, err := randomNonce()
+	if err != nil {
+		return nil, "", err
+	}
+
+	hello := protocol.NewHello(cfg.peerID(), cfg.infoHash, cfg.publicKey(), clientNonce, cfg.encrypt)
+	if err := protocol.SendMessage(conn, hello); err != nil {
+		return nil, "", fmt.Errorf("erro ao enviar HELLO: %w", err)
+	}
+
+	msg, err := receiveHandshakeMessage(conn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	challenge, ok := msg.(*protocol.ChallengeMsg)
+	if !ok {
+		return nil, "", fmt.Errorf("esperado CHALLENGE, recebido %s", msg.GetType())
+	}
+
+	// Servidor que informa o swarm precisa servir o mesmo conteúdo
+	if cfg.infoHash != "" && challenge.InfoHash != "" && challenge.InfoHash != cfg.infoHash {
+		return nil, "", fmt.Errorf("servidor está em outro swarm (%s)", challenge.InfoHash)
+	}
+
+	serverTranscript := handshakeTranscript("server", cfg.infoHash, clientNonce, challenge.Nonce, cfg.publicKey(), challenge.PublicKey)
+	clientTranscript := handshakeTranscript("client", cfg.infoHash, clientNonce, challenge.Nonce, cfg.publicKey(), challenge.PublicKey)
+
+	// O servidor deve conhecer a chave do swarm antes de qualquer outra coisa
+	if cfg.swarmKey != nil && !hmac.Equal(challenge.SwarmMAC, swarmMAC(cfg.swarmKey, serverTranscript)) {
+		return nil, "", fmt.Errorf("servidor não pertence ao swarm")
+	}
+
+	// Verifica a prova de identidade do servidor
+	if cfg.identity != nil {
+		if err := verifyPeerKey(challenge.PeerID, challenge.PublicKey); err != nil {
+			return nil, "", fmt.Errorf("identidade do servidor inválida: %w", err)
+		}
+		if !identity.Verify(challenge.PublicKey, serverTranscript, challenge.Signature) {
+			return nil, "", fmt.Errorf("assinatura do servidor inválida")
+		}
+	}
+
+	// Responde ao desafio
+	auth := protocol.NewAuth(nil, nil)
+	if cfg.identity != nil {
+		auth.Signature = cfg.identity.Sign(clientTranscript)
+	}
+	if cfg.swarmKey != nil {
+		auth.SwarmMAC = swarmMAC(cfg.swarmKey, clientTranscript)
+	}
+	if err := protocol.SendMessage(conn, auth); err != nil {
+		return nil, "", fmt.Errorf("erro ao enviar AUTH: %w", err)
+	}
+
+	msg, err = receiveHandshakeMessage(conn)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := msg.(*protocol.AuthOKMsg); !ok {
+		return nil, "", fmt.Errorf("esperado AUTH_OK, recebido %s", msg.GetType())
+	}
+
+	stream := conn
+	if cfg.encrypt {
+		session := handshakeTranscript("session", cfg.infoHash, clientNonce, challenge.Nonce, cfg.publicKey(), challenge.PublicKey)
+		stream, err = newSecureConn(conn, cfg.swarmKey, session, true)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return stream, challenge.PeerID, nil
+}
+
+// serverHandshake conclui o handshake iniciado por um HELLO. Retorna o stream
+// a ser usado (cifrado, se negociado), o ID verificado do cliente, se houver,
+// e o swarm pedido.
+func (s *Server) serverHandshake(conn net.Conn, hello *protocol.HelloMsg) (net.Conn, string, *swarm, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	cfg := s.handshake
+
+	// O cliente deve pedir um conteúdo que este servidor serve. Com chave do
+	// swarm, a conferência espera o MAC do cliente: antes dele, quem não tem a
+	// chave não descobre quais conteúdos são servidos aqui.
+	sw := s.routeSwarm(s.primary, hello.InfoHash)
+	if cfg.swarmKey == nil && sw == nil {
+		return nil, "", nil, fmt.Errorf("%w: %s", errUnknownSwarm, hello.InfoHash)
+	}
+
+	// Cifragem só é possível com a chave do swarm e precisa ser igual nos dois lados
+	if hello.Encrypt != cfg.encrypt {
+		return nil, "", nil, fmt.Errorf("cifragem do stream divergente (cliente: %t, servidor: %t)", hello.Encrypt, cfg.encrypt)
+	}
+
+	// Identidade é obrigatória quando o servidor possui uma; opcional caso contrário
+	if cfg.identity != nil || len(hello.PublicKey) > 0 {
+		if err := verifyPeerKey(hello.PeerID, hello.PublicKey); err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	serverNonce, err := randomNonce()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	serverTranscript := handshakeTranscript("server", hello.InfoHash, hello.Nonce, serverNonce, hello.PublicKey, cfg.publicKey())
+	clientTranscript := handshakeTranscript("client", hello.InfoHash, hello.Nonce, serverNonce, hello.PublicKey, cfg.publicKey())
+
+	// Prova a identidade do servidor (e a posse da chave do swarm) e desafia o cliente
+	challenge := protocol.NewChallenge(cfg.peerID(), "", cfg.publicKey(), serverNonce, nil, nil)
+	if cfg.swarmKey == nil {
+		challenge.InfoHash = sw.infoHash
+	}
+	if cfg.identity != nil {
+		challenge.Signature = cfg.identity.Sign(serverTranscript)
+	}
+	if cfg.swarmKey != nil {
+		challenge.SwarmMAC = swarmMAC(cfg.swarmKey, serverTranscript)
+	}
+	if err := protocol.SendMessage(conn, challenge); err != nil {
+		return nil, "", nil, fmt.Errorf("erro ao enviar CHALLENGE: %w", err)
+	}
+
+	msg, err := receiveHandshakeMessage(conn)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	auth, ok := msg.(*protocol.AuthMsg)
+	if !ok {
+		return nil, "", nil, fmt.Errorf("esperado AUTH, recebido %s", msg.GetType())
+	}
+
+	if cfg.swarmKey != nil {
+		if !hmac.Equal(auth.SwarmMAC, swarmMAC(cfg.swarmKey, clientTranscript)) {
+			return nil, "", nil, fmt.Errorf("cliente não possui a chave do swarm")
+		}
+		if sw == nil {
+			return nil, "", nil, fmt.Errorf("%w: %s", errUnknownSwarm, hello.InfoHash)
+		}
+	}
+
+	if len(hello.PublicKey) > 0 && !identity.Verify(hello.PublicKey, clientTranscript, auth.Signature) {
+		return nil, "", nil, fmt.Errorf("assinatura do cliente inválida")
+	}
+
+	// Regras de acesso por identidade (peers anônimos não casam com "peer:<id>")
+	if !s.accessList.Load().AllowsPeer(hello.PeerID) {
+		s.rejectedConns.Add(1)
+		return nil, "", nil, fmt.Errorf("peer %s negado pelas regras de acesso", hello.PeerID)
+	}
+
+	if err := protocol.SendMessage(conn, protocol.NewAuthOK()); err != nil {
+		return nil, "", nil, fmt.Errorf("erro ao enviar AUTH_OK: %w", err)
+	}
+
+	stream := conn
+	if cfg.encrypt {
+		session := handshakeTranscript("session", hello.InfoHash, hello.Nonce, serverNonce, hello.PublicKey, cfg.publicKey())
+		stream, err = newSecureConn(conn, cfg.swarmKey, session, false)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	return stream, hello.PeerID, sw, nil
+}
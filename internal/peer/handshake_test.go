@@ -0,0 +1,305 @@
+package peer
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/zatta/tp2-p2p/internal/identity"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+// handshakeResult é o resultado de um lado do handshake
+type handshakeResult struct {
+	stream net.Conn
+	peerID string
+	swarm  *swarm // escolhido pelo servidor
+	err    error
+}
+
+// newHandshakeServer cria um servidor que só conduz handshakes
+func newHandshakeServer(t *testing.T, options ServerOptions, infoHash string) *Server {
+	t.Helper()
+
+	s := NewServer(0, NewBlockManager(1), nil, nil, log.New(io.Discard, "", 0), options)
+	s.SetInfoHash(infoHash)
+	return s
+}
+
+// runHandshake conduz o handshake entre cfg e o servidor por uma conexão em
+// memória e retorna o resultado dos dois lados
+func runHandshake(t *testing.T, cfg handshakeConfig, s *Server) (client, server handshakeResult) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	done := make(chan handshakeResult, 1)
+	go func() {
+		stream, peerID, sw, err := s.authenticate(serverConn)
+		if err != nil {
+			// Libera o cliente que ainda espera uma resposta
+			serverConn.Close()
+		}
+		done <- handshakeResult{stream, peerID, sw, err}
+	}()
+
+	stream, peerID, err := clientHandshake(clientConn, cfg)
+	if err != nil {
+		// Libera o servidor que ainda espera o AUTH
+		clientConn.Close()
+	}
+
+	return handshakeResult{stream, peerID, nil, err}, <-done
+}
+
+// errorCode extrai o código de um ErrorMsg recebido do outro lado
+func errorCode(err error) string {
+	var errMsg *protocol.ErrorMsg
+	if errors.As(err, &errMsg) {
+		return errMsg.Code
+	}
+	return ""
+}
+
+// newTestIdentity gera uma identidade Ed25519 para o teste
+func newTestIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestHandshakeIdentity(t *testing.T) {
+	clientID := newTestIdentity(t)
+	serverID := newTestIdentity(t)
+	s := newHandshakeServer(t, ServerOptions{Identity: serverID}, "swarm")
+
+	client, server := runHandshake(t, handshakeConfig{identity: clientID, infoHash: "swarm"}, s)
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake falhou: cliente: %v, servidor: %v", client.err, server.err)
+	}
+	if client.peerID != serverID.PeerID() {
+		t.Errorf("cliente verificou o ID %q, esperado %q", client.peerID, serverID.PeerID())
+	}
+	if server.peerID != clientID.PeerID() {
+		t.Errorf("servidor verificou o ID %q, esperado %q", server.peerID, clientID.PeerID())
+	}
+}
+
+func TestHandshakeRejectsAnonymousClient(t *testing.T) {
+	s := newHandshakeServer(t, ServerOptions{Identity: newTestIdentity(t)}, "")
+
+	// Servidor com identidade exige que o cliente também prove a sua
+	client, server := runHandshake(t, handshakeConfig{}, s)
+	if server.err == nil {
+		t.Fatal("servidor aceitou cliente sem identidade")
+	}
+	if code := errorCode(client.err); code != protocol.ErrCodeAuthFailed {
+		t.Fatalf("cliente recebeu %v, esperado %s", client.err, protocol.ErrCodeAuthFailed)
+	}
+}
+
+func TestHandshakeRejectsForgedPeerID(t *testing.T) {
+	s := newHandshakeServer(t, ServerOptions{Identity: newTestIdentity(t)}, "")
+
+	// ID de outra identidade anunciado com a própria chave
+	forged := *newTestIdentity(t)
+	victim := newTestIdentity(t)
+	forged.PublicKey = victim.PublicKey
+
+	client, server := runHandshake(t, handshakeConfig{identity: &forged}, s)
+	if server.err == nil {
+		t.Fatal("servidor aceitou assinatura que não corresponde à chave anunciada")
+	}
+	if code := errorCode(client.err); code != protocol.ErrCodeAuthFailed {
+		t.Fatalf("cliente recebeu %v, esperado %s", client.err, protocol.ErrCodeAuthFailed)
+	}
+}
+
+func TestHandshakeAccessListByPeer(t *testing.T) {
+	allowed := newTestIdentity(t)
+	denied := newTestIdentity(t)
+
+	acl, err := NewAccessList(nil, []string{peerRulePrefix + denied.PeerID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newHandshakeServer(t, ServerOptions{Identity: newTestIdentity(t), AccessList: acl}, "")
+
+	if client, server := runHandshake(t, handshakeConfig{identity: allowed}, s); client.err != nil || server.err != nil {
+		t.Fatalf("peer permitido recusado: cliente: %v, servidor: %v", client.err, server.err)
+	}
+
+	client, server := runHandshake(t, handshakeConfig{identity: denied}, s)
+	if server.err == nil || errorCode(client.err) != protocol.ErrCodeAuthFailed {
+		t.Fatalf("peer negado aceito: cliente: %v, servidor: %v", client.err, server.err)
+	}
+	if s.RejectedConnections() != 1 {
+		t.Errorf("conexões negadas: %d, esperado 1", s.RejectedConnections())
+	}
+}
+
+func TestPeerRulesRequireHandshake(t *testing.T) {
+	acl, err := NewAccessList([]string{peerRulePrefix + "abc"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newHandshakeServer(t, ServerOptions{}, "")
+	if err := s.SetAccessList(acl); err == nil {
+		t.Fatal("regras peer:<id> aceitas sem handshake")
+	}
+
+	s = newHandshakeServer(t, ServerOptions{Identity: newTestIdentity(t)}, "")
+	if err := s.SetAccessList(acl); err != nil {
+		t.Fatalf("regras peer:<id> recusadas com identidade: %v", err)
+	}
+}
+
+func TestHandshakeSwarmKey(t *testing.T) {
+	key := DeriveSwarmKey("segredo")
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: key}, "swarm")
+
+	client, server := runHandshake(t, handshakeConfig{swarmKey: key, infoHash: "swarm"}, s)
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake falhou: cliente: %v, servidor: %v", client.err, server.err)
+	}
+}
+
+func TestHandshakeWrongSwarmKey(t *testing.T) {
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: DeriveSwarmKey("segredo")}, "swarm")
+
+	// O cliente confere o MAC do servidor primeiro e desiste antes do AUTH
+	client, server := runHandshake(t, handshakeConfig{swarmKey: DeriveSwarmKey("outro"), infoHash: "swarm"}, s)
+	if client.err == nil || server.err == nil {
+		t.Fatalf("handshake com chaves diferentes aceito: cliente: %v, servidor: %v", client.err, server.err)
+	}
+}
+
+func TestHandshakeHidesSwarmFromOutsiders(t *testing.T) {
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: DeriveSwarmKey("segredo")}, "swarm")
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		if _, _, _, err := s.authenticate(serverConn); err == nil {
+			t.Error("servidor aceitou cliente sem a chave do swarm")
+		}
+	}()
+
+	// Cliente sem a chave, pedindo outro conteúdo
+	nonce, err := randomNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.SendMessage(clientConn, protocol.NewHello("", "outro-swarm", nil, nonce, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := receiveHandshakeMessage(clientConn)
+	if err != nil {
+		t.Fatalf("servidor recusou antes do desafio: %v", err)
+	}
+	challenge, ok := msg.(*protocol.ChallengeMsg)
+	if !ok {
+		t.Fatalf("esperado CHALLENGE, recebido %s", msg.GetType())
+	}
+	if challenge.InfoHash != "" {
+		t.Fatalf("CHALLENGE revelou o swarm %q a quem não tem a chave", challenge.InfoHash)
+	}
+
+	if err := protocol.SendMessage(clientConn, protocol.NewAuth(nil, []byte("mac inválido"))); err != nil {
+		t.Fatal(err)
+	}
+	_, err = receiveHandshakeMessage(clientConn)
+	if code := errorCode(err); code != protocol.ErrCodeAuthFailed {
+		t.Fatalf("cliente sem a chave recebeu %v, esperado %s", err, protocol.ErrCodeAuthFailed)
+	}
+}
+
+func TestHandshakeUnknownSwarmAfterKey(t *testing.T) {
+	key := DeriveSwarmKey("segredo")
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: key}, "swarm")
+
+	// Quem prova a chave fica sabendo que o conteúdo não é servido aqui
+	client, server := runHandshake(t, handshakeConfig{swarmKey: key, infoHash: "outro-swarm"}, s)
+	if !errors.Is(server.err, errUnknownSwarm) {
+		t.Fatalf("servidor: %v, esperado swarm desconhecido", server.err)
+	}
+	if code := errorCode(client.err); code != protocol.ErrCodeUnknownSwarm {
+		t.Fatalf("cliente recebeu %v, esperado %s", client.err, protocol.ErrCodeUnknownSwarm)
+	}
+}
+
+func TestHandshakeEncryptedStream(t *testing.T) {
+	key := DeriveSwarmKey("segredo")
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: key, EncryptStream: true}, "swarm")
+
+	client, server := runHandshake(t, handshakeConfig{swarmKey: key, encrypt: true, infoHash: "swarm"}, s)
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake falhou: cliente: %v, servidor: %v", client.err, server.err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- protocol.SendMessage(client.stream, protocol.NewRequestBlock(7))
+	}()
+
+	data, err := protocol.ReceiveMessage(server.stream)
+	if err != nil {
+		t.Fatalf("erro ao receber pelo stream cifrado: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request, ok := msg.(*protocol.RequestBlockMsg); !ok || request.BlockID != 7 {
+		t.Fatalf("mensagem recebida pelo stream cifrado: %+v", msg)
+	}
+}
+
+func TestHandshakeEncryptionMismatch(t *testing.T) {
+	key := DeriveSwarmKey("segredo")
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: key, EncryptStream: true}, "swarm")
+
+	client, server := runHandshake(t, handshakeConfig{swarmKey: key, infoHash: "swarm"}, s)
+	if server.err == nil || errorCode(client.err) != protocol.ErrCodeAuthFailed {
+		t.Fatalf("cifragem divergente aceita: cliente: %v, servidor: %v", client.err, server.err)
+	}
+}
+
+func TestHandshakeSelectsSwarm(t *testing.T) {
+	key := DeriveSwarmKey("segredo")
+	s := newHandshakeServer(t, ServerOptions{SwarmKey: key}, "swarm")
+
+	other := newSwarm(NewBlockManager(1), nil, nil, nil)
+	other.infoHash = "outro-swarm"
+	if err := s.addSwarm(other); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []*swarm{s.primary, other} {
+		client, server := runHandshake(t, handshakeConfig{swarmKey: key, infoHash: want.infoHash}, s)
+		if client.err != nil || server.err != nil {
+			t.Fatalf("%s: cliente: %v, servidor: %v", want.infoHash, client.err, server.err)
+		}
+		if server.swarm != want {
+			t.Errorf("handshake para %s escolheu o swarm %s", want.infoHash, server.swarm.infoHash)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package peer
+
+import (
+	"fmt"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+)
+
+// PeerState representa a etapa do ciclo de vida do peer
+type PeerState string
+
+const (
+	StateInitializing PeerState = "initializing" // criado, ainda não iniciado
+	StateDownloading  PeerState = "downloading"  // baixando blocos dos vizinhos
+	StateVerifying    PeerState = "verifying"    // validando o arquivo completo
+	StateSeeding      PeerState = "seeding"      // arquivo completo e válido sendo servido (volta a baixar se o conteúdo ao vivo crescer)
+	StateRepairing    PeerState = "repairing"    // reverificando blocos após falha de integridade
+	StateStopping     PeerState = "stopping"     // drenando conexões e transferências
+	StateStopped      PeerState = "stopped"      // encerrado
+)
+
+// peerTransitions lista, para cada estado, os estados de destino permitidos
+var peerTransitions = map[PeerState][]PeerState{
+	StateInitializing: {StateDownloading, StateSeeding, StateStopping},
+	StateDownloading:  {StateVerifying, StateStopping},
+	StateVerifying:    {StateSeeding, StateRepairing, StateStopping},
+	StateSeeding:      {StateDownloading, StateRepairing, StateStopping},
+	StateRepairing:    {StateDownloading, StateStopping},
+	StateStopping:     {StateStopped},
+	StateStopped:      {},
+}
+
+// State retorna o estado atual do peer
+func (p *Peer) State() PeerState {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	return p.state
+}
+
+// CurrentMode retorna o papel efetivo do peer: um leecher passa a atuar como
+// seeder depois que o arquivo é validado
+func (p *Peer) CurrentMode() PeerMode {
+	if p.State() == StateSeeding {
+		return ModeSeeder
+	}
+	return p.Mode
+}
+
+// setState muda o estado do peer, recusando transições fora da tabela
+func (p *Peer) setState(to PeerState) error {
+	return p.transition(nil, to)
+}
+
+// compareAndSetState muda para to apenas se o estado atual for from
+func (p *Peer) compareAndSetState(from, to PeerState) bool {
+	return p.transition(&from, to) == nil
+}
+
+// transition aplica a mudança de estado de forma atômica e a publica como evento
+func (p *Peer) transition(expected *PeerState, to PeerState) error {
+	p.stateMu.Lock()
+	from := p.state
+	if expected != nil && from != *expected {
+		p.stateMu.Unlock()
+		return fmt.Errorf("estado atual é %s, esperado %s", from, *expected)
+	}
+	if !canTransitionPeer(from, to) {
+		p.stateMu.Unlock()
+		return fmt.Errorf("transição de estado inválida: %s -> %s", from, to)
+	}
+	p.state = to
+	p.stateMu.Unlock()
+
+	p.Logger.Printf("[PEER] Estado: %s -> %s", from, to)
+	p.BlockManager.Events().Publish(Event{Type: EventStateChanged, BlockID: -1, State: to})
+	return nil
+}
+
+// canTransitionPeer verifica se a mudança de estado é permitida
+func canTransitionPeer(from, to PeerState) bool {
+	for _, allowed := range peerTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// repairBlocks relê cada bloco descrito pelos metadados validados, marca como
+// corrompidos os que não conferem e retorna os IDs de todos os corrompidos
+func (p *Peer) repairBlocks(meta *metadata.Metadata) []int {
+	totalBlocks := meta.TotalBlocks
+	p.Logger.Printf("[PEER] Reverificando %d blocos no disco...", totalBlocks)
+
+	var bad []int
+	for blockID := 0; blockID < totalBlocks; blockID++ {
+		data, err := p.storage.ReadBlock(blockID)
+		if err == nil {
+			err = p.verifier.VerifyLocal(blockID, data)
+		}
+		if err == nil {
+			// Íntegro no disco (reativa blocos marcados como corrompidos à toa)
+			p.BlockManager.MarkBlockAvailable(blockID)
+			continue
+		}
+
+		p.BlockManager.MarkBlockCorrupt(blockID)
+		if p.BlockManager.GetBlockState(blockID) == BlockCorrupt {
+			bad = append(bad, blockID)
+		}
+	}
+
+	p.Logger.Printf("[PEER] Reparo: %d blocos divergentes", len(bad))
+	return bad
+}
@@ -0,0 +1,226 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+const (
+	// livePollInterval é o intervalo entre consultas por extensões do
+	// conteúdo ao vivo
+	livePollInterval = 2 * time.Second
+
+	// extensionFetchTimeout limita cada consulta de extensão a um vizinho
+	extensionFetchTimeout = 10 * time.Second
+)
+
+// CurrentMetadata retorna os metadados atuais, que mudam quando o conteúdo ao
+// vivo recebe extensões
+func (p *Peer) CurrentMetadata() *metadata.Metadata {
+	p.metaMu.RLock()
+	defer p.metaMu.RUnlock()
+
+	return p.Metadata
+}
+
+// liveStatus descreve o ponto em que está o conteúdo ao vivo, para os logs
+func liveStatus(meta *metadata.Metadata) string {
+	return fmt.Sprintf("extensão %d, %d blocos, %d bytes", meta.Live.Seq, meta.TotalBlocks, meta.FileSize)
+}
+
+// followLive acompanha o crescimento do conteúdo ao vivo até a extensão
+// final ou o encerramento do peer. O seeder relê o arquivo de metadados
+// atualizado pelo publicador; os demais consultam os vizinhos.
+func (p *Peer) followLive() {
+	ticker := time.NewTicker(livePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopLive:
+			return
+		case <-ticker.C:
+		}
+
+		current := p.CurrentMetadata()
+
+		var next *metadata.Metadata
+		var err error
+		if p.Mode == ModeSeeder {
+			next, err = p.loadPublishedMetadata(current)
+		} else {
+			next = p.fetchExtension(current)
+		}
+		if err != nil {
+			p.Logger.Printf("[PEER] Erro ao ler extensão do conteúdo ao vivo: %v", err)
+			continue
+		}
+		if next == nil {
+			continue
+		}
+
+		if err := p.applyMetadata(current, next); err != nil {
+			p.Logger.Printf("[PEER] Erro ao aplicar extensão %d: %v", next.Live.Seq, err)
+			continue
+		}
+		if next.Live.Final {
+			return
+		}
+	}
+}
+
+// loadPublishedMetadata relê o arquivo de metadados do publicador e retorna a
+// versão nova, se houver, conferida como uma extensão dos metadados atuais
+func (p *Peer) loadPublishedMetadata(current *metadata.Metadata) (*metadata.Metadata, error) {
+	published, err := metadata.LoadFromFile(p.metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	if !published.IsLive() || published.Live.Seq <= current.Live.Seq {
+		return nil, nil
+	}
+
+	ext, err := published.ExtensionFrom(current.TotalBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return current.ApplyExtension(ext)
+}
+
+// fetchExtension consulta os vizinhos e retorna os metadados estendidos pela
+// primeira extensão válida (nil se nenhum vizinho tem novidade)
+func (p *Peer) fetchExtension(current *metadata.Metadata) *metadata.Metadata {
+	for _, neighbor := range p.Neighbors {
+		ext, err := fetchExtensionFrom(neighbor.Address, p.InfoHash, p.Client.options, current)
+		if err != nil {
+			p.Logger.Printf("[CLIENT] Erro ao consultar extensões de %s: %v", neighbor.Address, err)
+			continue
+		}
+		if ext == nil {
+			continue
+		}
+
+		next, err := current.ApplyExtension(ext)
+		if err != nil {
+			p.Logger.Printf("[CLIENT] Extensão recusada de %s: %v", neighbor.Address, err)
+			continue
+		}
+
+		p.Logger.Printf("[CLIENT] Extensão %d obtida de %s", next.Live.Seq, neighbor.Address)
+		return next
+	}
+
+	return nil
+}
+
+// fetchExtensionFrom pede a um vizinho os blocos publicados depois dos
+// metadados atuais; retorna nil se o vizinho não tem extensão mais nova
+func fetchExtensionFrom(address, infoHash string, options ClientOptions, current *metadata.Metadata) (*metadata.Extension, error) {
+	conn, _, err := dialNeighbor(address, options, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(extensionFetchTimeout))
+
+	request := protocol.NewRequestExtension(infoHash, current.Live.Seq, current.TotalBlocks)
+	if err := protocol.SendMessage(conn, request); err != nil {
+		return nil, fmt.Errorf("erro ao enviar REQUEST_EXTENSION: %w", err)
+	}
+
+	msgData, err := protocol.ReceiveMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao receber resposta: %w", err)
+	}
+
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao parsear resposta: %w", err)
+	}
+
+	switch m := msg.(type) {
+	case *protocol.ExtensionMsg:
+		if m.InfoHash != infoHash {
+			return nil, fmt.Errorf("resposta para o swarm %s", m.InfoHash)
+		}
+		if len(m.Data) == 0 {
+			return nil, nil
+		}
+
+		var ext metadata.Extension
+		if err := json.Unmarshal(m.Data, &ext); err != nil {
+			return nil, fmt.Errorf("erro ao deserializar extensão: %w", err)
+		}
+		return &ext, nil
+
+	case *protocol.ErrorMsg:
+		return nil, fmt.Errorf("erro do servidor: %w", m)
+
+	default:
+		return nil, fmt.Errorf("tipo de mensagem inesperado: %T", msg)
+	}
+}
+
+// applyMetadata passa a usar os metadados estendidos: o armazenamento, o
+// verificador e o servidor aceitam os blocos novos antes de o block manager
+// torná-los elegíveis para download. O seeder já tem os blocos novos no disco.
+func (p *Peer) applyMetadata(prev, next *metadata.Metadata) error {
+	growable, ok := p.storage.(storage.Growable)
+	if !ok {
+		return fmt.Errorf("armazenamento não suporta crescimento")
+	}
+	if err := growable.Grow(next.FileSize, next.Layout()); err != nil {
+		return err
+	}
+
+	p.verifier.SetMetadata(next)
+	p.swarm.setMetadata(next)
+
+	p.BlockManager.Grow(next.TotalBlocks)
+	if p.Mode == ModeSeeder {
+		for blockID := prev.TotalBlocks; blockID < next.TotalBlocks; blockID++ {
+			p.BlockManager.MarkBlockAvailable(blockID)
+		}
+	}
+
+	// Publicados só depois do block manager: quem vê os metadados novos já vê
+	// os blocos novos como faltantes (ver waitForDownloadCompletion)
+	p.metaMu.Lock()
+	p.Metadata = next
+	p.metaMu.Unlock()
+
+	p.Logger.Printf("[PEER] Conteúdo ao vivo cresceu %d blocos (%s)", next.TotalBlocks-prev.TotalBlocks, liveStatus(next))
+	if next.Live.Final {
+		p.Logger.Printf("[PEER] Conteúdo ao vivo encerrado pelo publicador")
+	}
+	p.BlockManager.Events().Publish(Event{Type: EventContentExtended, BlockID: -1})
+
+	if p.Client != nil {
+		p.resumeDownload()
+	}
+
+	return nil
+}
+
+// resumeDownload volta a baixar após uma extensão. Durante a verificação ou
+// o reparo, waitForDownloadCompletion percebe os blocos novos sozinho.
+func (p *Peer) resumeDownload() {
+	switch p.State() {
+	case StateDownloading:
+		if p.Client.Resume() {
+			go p.waitForDownloadCompletion()
+		}
+
+	case StateSeeding:
+		if !p.BlockManager.IsDownloadComplete() && p.compareAndSetState(StateSeeding, StateDownloading) {
+			p.Client.Resume()
+			go p.waitForDownloadCompletion()
+		}
+	}
+}
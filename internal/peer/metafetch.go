@@ -0,0 +1,199 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+const (
+	// maxMetadataSize limita o tamanho dos metadados aceitos de um vizinho
+	maxMetadataSize = 64 * 1024 * 1024
+
+	// metadataFetchTimeout limita a transferência dos metadados de um vizinho
+	metadataFetchTimeout = 60 * time.Second
+
+	// metadataFetchRounds é o número de passadas pela lista de vizinhos
+	metadataFetchRounds = 3
+)
+
+// loadMetadata lê os metadados de config.MetadataPath ou, quando o leecher só
+// conhece o info hash, obtém-nos dos vizinhos e os salva em disco
+func loadMetadata(config PeerConfig, options ClientOptions) (*metadata.Metadata, error) {
+	if config.InfoHash == "" {
+		return metadata.LoadFromFile(config.MetadataPath)
+	}
+
+	infoHash, err := metadata.ParseInfoHash(config.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	metaPath := storedMetadataPath(config, infoHash)
+
+	// Metadados já salvos (ex: execução anterior) precisam ser os pedidos
+	if _, err := os.Stat(metaPath); err == nil {
+		meta, err := metadata.LoadFromFile(metaPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkInfoHash(meta, infoHash); err != nil {
+			return nil, fmt.Errorf("%s: %w", metaPath, err)
+		}
+		return meta, nil
+	}
+
+	if config.Mode != ModeLeecher {
+		return nil, fmt.Errorf("metadados não encontrados: %s", metaPath)
+	}
+
+	config.Logger.Printf("[PEER] Obtendo metadados de %s com os vizinhos...", infoHash)
+	meta, err := FetchMetadata(config.Neighbors, infoHash, options, config.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório dos metadados: %w", err)
+	}
+	if err := meta.SaveToFile(metaPath); err != nil {
+		return nil, err
+	}
+	config.Logger.Printf("[PEER] Metadados salvos em %s", metaPath)
+
+	return meta, nil
+}
+
+// storedMetadataPath retorna onde ficam os metadados obtidos pelo info hash:
+// config.MetadataPath ou, se vazio, um arquivo em download_dir
+func storedMetadataPath(config PeerConfig, infoHash string) string {
+	if config.MetadataPath != "" {
+		return config.MetadataPath
+	}
+	return filepath.Join(config.DownloadDir, infoHash+".meta.json")
+}
+
+// FetchMetadata obtém dos vizinhos os metadados identificados por infoHash,
+// conferindo o info hash do resultado antes de aceitá-lo
+func FetchMetadata(neighbors []NeighborInfo, infoHash string, options ClientOptions, logger *log.Logger) (*metadata.Metadata, error) {
+	if len(neighbors) == 0 {
+		return nil, fmt.Errorf("nenhum vizinho para obter os metadados")
+	}
+
+	var lastErr error
+	for round := 1; round <= metadataFetchRounds; round++ {
+		for _, neighbor := range neighbors {
+			meta, err := fetchMetadataFrom(neighbor.Address, infoHash, options)
+			if err == nil {
+				logger.Printf("[CLIENT] Metadados obtidos de %s", neighbor.Address)
+				return meta, nil
+			}
+
+			logger.Printf("[CLIENT] Erro ao obter metadados de %s (tentativa %d/%d): %v",
+				neighbor.Address, round, metadataFetchRounds, err)
+			lastErr = err
+		}
+
+		if round < metadataFetchRounds {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	return nil, fmt.Errorf("metadados não obtidos: %w", lastErr)
+}
+
+// fetchMetadataFrom baixa os metadados de um vizinho, parte por parte
+func fetchMetadataFrom(address, infoHash string, options ClientOptions) (*metadata.Metadata, error) {
+	conn, _, err := dialNeighbor(address, options, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(metadataFetchTimeout))
+
+	var data []byte
+	total := -1
+	for piece := 0; total < 0 || len(data) < total; piece++ {
+		m, err := requestMetadataPiece(conn, infoHash, piece)
+		if err != nil {
+			return nil, err
+		}
+
+		// A primeira parte informa o tamanho total; as demais devem concordar
+		if total < 0 {
+			if m.TotalSize <= 0 || m.TotalSize > maxMetadataSize {
+				return nil, fmt.Errorf("tamanho de metadados inválido: %d bytes", m.TotalSize)
+			}
+			total = m.TotalSize
+			data = make([]byte, 0, total)
+		} else if m.TotalSize != total {
+			return nil, fmt.Errorf("tamanho dos metadados mudou de %d para %d bytes", total, m.TotalSize)
+		}
+
+		if len(m.Data) == 0 || len(data)+len(m.Data) > total {
+			return nil, fmt.Errorf("parte %d dos metadados com tamanho inválido: %d bytes", piece, len(m.Data))
+		}
+		data = append(data, m.Data...)
+	}
+
+	meta, err := metadata.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInfoHash(meta, infoHash); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// requestMetadataPiece envia REQUEST_METADATA e aguarda a parte pedida
+func requestMetadataPiece(conn net.Conn, infoHash string, piece int) (*protocol.MetadataMsg, error) {
+	if err := protocol.SendMessage(conn, protocol.NewRequestMetadata(infoHash, piece)); err != nil {
+		return nil, fmt.Errorf("erro ao enviar REQUEST_METADATA: %w", err)
+	}
+
+	msgData, err := protocol.ReceiveMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao receber resposta: %w", err)
+	}
+
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao parsear resposta: %w", err)
+	}
+
+	switch m := msg.(type) {
+	case *protocol.MetadataMsg:
+		if m.Piece != piece || m.InfoHash != infoHash {
+			return nil, fmt.Errorf("resposta para parte %d de %s, esperado parte %d", m.Piece, m.InfoHash, piece)
+		}
+		return m, nil
+
+	case *protocol.ErrorMsg:
+		return nil, fmt.Errorf("erro do servidor: %w", m)
+
+	default:
+		return nil, fmt.Errorf("tipo de mensagem inesperado: %T", msg)
+	}
+}
+
+// checkInfoHash confere se os metadados correspondem ao info hash esperado
+func checkInfoHash(meta *metadata.Metadata, infoHash string) error {
+	got, err := meta.InfoHash()
+	if err != nil {
+		return err
+	}
+	if got != infoHash {
+		return errors.New("metadados não correspondem ao info hash")
+	}
+	return nil
+}
@@ -1,13 +1,19 @@
 package peer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/identity"
 	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/storage"
 )
 
 // PeerMode define o modo de operação do peer
@@ -18,20 +24,33 @@ const (
 	ModeLeecher PeerMode = "leecher" // Peer que está baixando o arquivo
 )
 
-// Peer representa um nó P2P que atua como cliente e servidor
+// Peer representa um nó P2P que atua como cliente e servidor. Mode é o papel
+// configurado e não muda; o papel efetivo vem de State (ver CurrentMode).
 type Peer struct {
 	ID           string
 	Mode         PeerMode
 	Port         int
 	FilePath     string
 	DownloadDir  string
-	Metadata     *metadata.Metadata
+	Metadata     *metadata.Metadata // cresce com o conteúdo ao vivo; leia com CurrentMetadata
+	InfoHash     string             // identificador do swarm (ver metadata.InfoHash)
 	Neighbors    []NeighborInfo
 	BlockManager *BlockManager
 	Server       *Server
 	Client       *Client
 	Logger       *log.Logger
+	Identity     *identity.Identity
 	startTime    time.Time
+	state        PeerState
+	stateMu      sync.Mutex
+	verifier     *BlockVerifier
+	storage      storage.Storage
+	swarm        *swarm      // conteúdo servido por Server
+	sharedServer bool        // Server pertence a outro peer (ver PeerConfig.SharedServer)
+	reused       deltaResult // blocos copiados da versão anterior
+	metaMu       sync.RWMutex
+	metadataPath string        // relido pelo seeder de conteúdo ao vivo
+	stopLive     chan struct{} // encerra o acompanhamento do conteúdo ao vivo
 }
 
 // PeerConfig contém a configuração de um peer
@@ -41,32 +60,158 @@ type PeerConfig struct {
 	Port         int
 	FilePath     string
 	MetadataPath string
+	InfoHash     string // Leecher sem metadados os obtém dos vizinhos por este hash
 	DownloadDir  string
 	Neighbors    []NeighborInfo
 	Logger       *log.Logger
+	Server       ServerOptions
+	TLS          *TLSOptions // TLS mútuo para servidor e cliente (nil = desabilitado)
+	IdentityPath string      // Chave Ed25519 do peer; o ID passa a ser derivado dela
+
+	// TrustedPublishers lista chaves ("ed25519:<hex>") aceitas como assinantes
+	// dos metadados; se não vazia, metadados sem assinatura válida são recusados
+	TrustedPublishers []string
+
+	// BasePath e BaseMetadataPath apontam para uma versão anterior do conteúdo
+	// já presente no disco; o leecher copia dela os blocos inalterados e só
+	// baixa os demais
+	BasePath         string
+	BaseMetadataPath string
+
+	// SwarmKey restringe o swarm a peers que conhecem o segredo;
+	// EncryptStream cifra o tráfego com chaves derivadas dele
+	SwarmKey      string
+	EncryptStream bool
+
+	// SharedServer serve o conteúdo pelo servidor de outro peer, de modo que
+	// um daemon atenda vários conteúdos em uma porta, roteados pelo info hash.
+	// Port, Server e TLS valem apenas para o peer dono do servidor.
+	SharedServer *Server
 }
 
 // NewPeer cria um novo peer
 func NewPeer(config PeerConfig) (*Peer, error) {
-	// Carrega metadados
-	meta, err := metadata.LoadFromFile(config.MetadataPath)
+	// Carrega (ou cria) a identidade do peer
+	var id *identity.Identity
+	peerID := config.ID
+	if config.IdentityPath != "" {
+		var created bool
+		var err error
+		id, created, err = identity.LoadOrGenerate(config.IdentityPath)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao carregar identidade: %w", err)
+		}
+		if created {
+			config.Logger.Printf("[PEER] Nova identidade gerada em %s", config.IdentityPath)
+		}
+
+		peerID = id.PeerID()
+		config.Logger.Printf("[PEER] Identidade: %s (chave %s)", peerID, identity.EncodePublicKey(id.PublicKey))
+		config.Server.Identity = id
+	}
+
+	// Swarm privado
+	if config.EncryptStream && config.SwarmKey == "" {
+		return nil, fmt.Errorf("cifragem do stream requer swarm_key")
+	}
+	// Regras por identidade são conferidas no handshake: sem ele seriam ignoradas
+	if config.Server.AccessList.HasPeerRules() && id == nil && config.SwarmKey == "" {
+		return nil, fmt.Errorf("regras de acesso \"peer:<id>\" requerem identity_key ou swarm_key")
+	}
+
+	clientOptions := ClientOptions{Identity: id}
+	if config.SwarmKey != "" {
+		swarmKey := DeriveSwarmKey(config.SwarmKey)
+		config.Server.SwarmKey = swarmKey
+		config.Server.EncryptStream = config.EncryptStream
+		clientOptions.SwarmKey = swarmKey
+		clientOptions.EncryptStream = config.EncryptStream
+		config.Logger.Printf("[PEER] Swarm privado (cifragem do stream: %t)", config.EncryptStream)
+	}
+
+	// Carrega certificados TLS, se configurado
+	if config.TLS != nil {
+		serverTLS, clientTLS, err := LoadTLSConfig(*config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao configurar TLS: %w", err)
+		}
+		config.Server.TLSConfig = serverTLS
+		clientOptions.TLSConfig = clientTLS
+	}
+
+	// Carrega metadados (do disco ou, só com o info hash, dos vizinhos)
+	meta, err := loadMetadata(config, clientOptions)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao carregar metadados: %w", err)
 	}
 
+	// Conteúdo ao vivo: o info hash fixa a chave do publicador, que precisa
+	// ter assinado os metadados
+	if meta.IsLive() {
+		if err := meta.VerifyPublisher(); err != nil {
+			return nil, fmt.Errorf("metadados ao vivo recusados: %w", err)
+		}
+		config.Logger.Printf("[PEER] Conteúdo ao vivo (%s)", liveStatus(meta))
+	}
+
+	// Verifica a assinatura do publicador
+	if len(config.TrustedPublishers) > 0 {
+		trusted, err := metadata.ParseTrustedKeys(config.TrustedPublishers)
+		if err != nil {
+			return nil, fmt.Errorf("erro nas chaves de publicadores: %w", err)
+		}
+		if err := meta.VerifySignature(trusted); err != nil {
+			return nil, fmt.Errorf("metadados recusados: %w", err)
+		}
+		config.Logger.Printf("[PEER] Metadados assinados por %s", meta.Signature.PublicKey)
+	}
+
+	// Identificador do swarm, anunciado no handshake e no REQUEST_INFO
+	infoHash, err := meta.InfoHash()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao calcular info hash: %w", err)
+	}
+	config.Logger.Printf("[PEER] Info hash: %s", infoHash)
+
+	// Hashes não criptográficos só protegem contra erros acidentais
+	if algorithm, err := meta.HashAlgorithm(); err == nil && !algorithm.Cryptographic {
+		config.Logger.Printf("[PEER] Aviso: metadados usam %s, que não protege contra peers maliciosos", algorithm.Name)
+	}
+
+	// Verificador de blocos (hashes listados ou raiz de Merkle)
+	verifier, err := NewBlockVerifier(meta)
+	if err != nil {
+		return nil, fmt.Errorf("metadados de Merkle inválidos: %w", err)
+	}
+
 	// Cria block manager
 	blockManager := NewBlockManager(meta.TotalBlocks)
 
 	var filePath string
+	var store storage.Storage
+	var reused deltaResult
 
 	// Configura baseado no modo
 	if config.Mode == ModeSeeder {
-		// Seeder: verifica se arquivo existe
+		// Seeder: verifica se arquivo (ou diretório) existe
 		if _, err := os.Stat(config.FilePath); err != nil {
 			return nil, fmt.Errorf("arquivo não encontrado: %w", err)
 		}
 		filePath = config.FilePath
 
+		store, err = meta.OpenStorage(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao abrir arquivo: %w", err)
+		}
+
+		// No modo Merkle o seeder precisa da árvore completa para gerar provas
+		if meta.IsMerkle() {
+			if err := verifier.LoadTree(store); err != nil {
+				return nil, fmt.Errorf("erro ao montar árvore de Merkle: %w", err)
+			}
+			config.Logger.Printf("[PEER] Árvore de Merkle montada (raiz %s)", meta.Merkle.Root)
+		}
+
 		// Marca todos os blocos como disponíveis
 		blockManager.MarkAllBlocksAvailable()
 		config.Logger.Printf("[PEER] Modo Seeder - Arquivo completo disponível: %s", filePath)
@@ -77,37 +222,98 @@ func NewPeer(config PeerConfig) (*Peer, error) {
 			return nil, fmt.Errorf("erro ao criar diretório de download: %w", err)
 		}
 
-		filePath = fmt.Sprintf("%s/%s", config.DownloadDir, meta.FileName)
+		filePath = filepath.Join(config.DownloadDir, meta.FileName)
+
+		store, err = meta.OpenStorage(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao abrir arquivo de download: %w", err)
+		}
+
+		// A criação trunca o destino, que não pode ser a própria versão anterior
+		if config.BasePath != "" && samePath(config.BasePath, filePath) {
+			return nil, fmt.Errorf("versão anterior %s não pode ser o próprio destino do download", config.BasePath)
+		}
 
-		// Cria arquivo vazio com tamanho correto
-		if err := createEmptyFile(filePath, meta.FileSize); err != nil {
+		// Cria arquivo vazio com tamanho correto (ou a árvore de arquivos)
+		if err := store.Create(); err != nil {
 			return nil, fmt.Errorf("erro ao criar arquivo de download: %w", err)
 		}
 
-		config.Logger.Printf("[PEER] Modo Leecher - Arquivo preparado: %s", filePath)
+		// Copia os blocos inalterados da versão anterior
+		if config.BasePath != "" {
+			baseMeta, err := metadata.LoadFromFile(config.BaseMetadataPath)
+			if err != nil {
+				return nil, fmt.Errorf("erro ao carregar metadados da versão anterior: %w", err)
+			}
+			reused, err = reuseBaseBlocks(meta, baseMeta, config.BasePath, store, blockManager, verifier, config.Logger)
+			if err != nil {
+				return nil, err
+			}
+			config.Logger.Printf("[PEER] Versão anterior: %d/%d blocos reaproveitados (%d bytes)",
+				reused.blocks, meta.TotalBlocks, reused.bytes)
+		}
+
+		if meta.IsMultiFile() {
+			config.Logger.Printf("[PEER] Modo Leecher - Diretório preparado: %s (%d arquivos)", filePath, len(meta.Files))
+		} else {
+			config.Logger.Printf("[PEER] Modo Leecher - Arquivo preparado: %s", filePath)
+		}
 	}
 
-	// Cria servidor
-	server := NewServer(config.Port, blockManager, meta, filePath, config.Logger)
+	// Cria servidor (ou registra o conteúdo no servidor compartilhado)
+	var server *Server
+	var served *swarm
+	port := config.Port
+	if config.SharedServer != nil {
+		server = config.SharedServer
+		port = server.port
+		served = newSwarm(blockManager, meta, store, verifier)
+		served.infoHash = infoHash
+		if err := server.addSwarm(served); err != nil {
+			return nil, fmt.Errorf("erro ao registrar conteúdo no servidor: %w", err)
+		}
+	} else {
+		server = NewServer(config.Port, blockManager, meta, store, config.Logger, config.Server)
+		server.SetBlockVerifier(verifier)
+		server.SetInfoHash(infoHash)
+		served = server.primary
+	}
 
 	// Cria cliente (apenas para leechers com vizinhos)
 	var client *Client
 	if config.Mode == ModeLeecher && len(config.Neighbors) > 0 {
-		client = NewClient(config.Neighbors, blockManager, meta, filePath, config.Logger)
+		client = NewClient(config.Neighbors, blockManager, meta, store, config.Logger, clientOptions)
+		client.SetBlockVerifier(verifier)
+		client.SetInfoHash(infoHash)
 	}
 
 	peer := &Peer{
-		ID:           config.ID,
+		ID:           peerID,
 		Mode:         config.Mode,
-		Port:         config.Port,
+		Port:         port,
 		FilePath:     filePath,
 		DownloadDir:  config.DownloadDir,
 		Metadata:     meta,
+		InfoHash:     infoHash,
 		Neighbors:    config.Neighbors,
 		BlockManager: blockManager,
 		Server:       server,
 		Client:       client,
 		Logger:       config.Logger,
+		Identity:     id,
+		state:        StateInitializing,
+		verifier:     verifier,
+		storage:      store,
+		swarm:        served,
+		sharedServer: config.SharedServer != nil,
+		reused:       reused,
+		metadataPath: storedMetadataPath(config, infoHash),
+		stopLive:     make(chan struct{}),
+	}
+
+	// Blocos corrompidos no disco voltam para a fila de download
+	if client != nil {
+		served.onCorruptBlock = peer.handleCorruptBlock
 	}
 
 	return peer, nil
@@ -118,13 +324,29 @@ func (p *Peer) Start() error {
 	p.startTime = time.Now()
 	p.Logger.Printf("[PEER] Iniciando peer %s na porta %d (modo: %s)", p.ID, p.Port, p.Mode)
 
-	// Inicia servidor
-	if err := p.Server.Start(); err != nil {
-		return fmt.Errorf("erro ao iniciar servidor: %w", err)
+	// Inicia servidor (o compartilhado já foi iniciado pelo seu dono)
+	if !p.sharedServer {
+		if err := p.Server.Start(); err != nil {
+			return fmt.Errorf("erro ao iniciar servidor: %w", err)
+		}
+	}
+
+	// Conteúdo ao vivo: o seeder acompanha o arquivo de metadados e os demais,
+	// os vizinhos
+	if meta := p.CurrentMetadata(); meta.IsLive() && !meta.Live.Final && (p.Mode == ModeSeeder || p.Client != nil) {
+		go p.followLive()
+	}
+
+	if p.Mode != ModeLeecher {
+		return p.setState(StateSeeding)
+	}
+
+	if err := p.setState(StateDownloading); err != nil {
+		return err
 	}
 
 	// Se for leecher, inicia cliente para download
-	if p.Mode == ModeLeecher && p.Client != nil {
+	if p.Client != nil {
 		p.Logger.Printf("[PEER] Iniciando download de %d vizinhos", len(p.Neighbors))
 		p.Client.Start()
 
@@ -135,17 +357,50 @@ func (p *Peer) Start() error {
 	return nil
 }
 
-// Stop para o peer
-func (p *Peer) Stop() {
+// Stop para o peer, aguardando transferências e escritas em andamento até o
+// prazo de ctx. Retorna erro se alguma conexão precisou ser fechada à força.
+func (p *Peer) Stop(ctx context.Context) error {
 	p.Logger.Printf("[PEER] Parando peer %s", p.ID)
 
+	if err := p.setState(StateStopping); err != nil {
+		return err
+	}
+	close(p.stopLive)
+
+	var errs []error
+
+	// Para o cliente primeiro para que nenhum bloco novo seja gravado
 	if p.Client != nil {
-		p.Client.Stop()
+		if err := p.Client.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("cliente: %w", err))
+		}
 	}
 
-	if p.Server != nil {
-		p.Server.Stop()
+	// Servidor compartilhado apenas deixa de servir este conteúdo
+	if p.sharedServer {
+		p.Server.removeSwarm(p.swarm)
+	} else if p.Server != nil {
+		if err := p.Server.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("servidor: %w", err))
+		}
 	}
+
+	p.setState(StateStopped)
+
+	// Nenhum evento é publicado após o encerramento; canais dos assinantes são fechados
+	p.BlockManager.Events().Close()
+
+	return errors.Join(errs...)
+}
+
+// Subscribe assina os eventos do peer por canal (ver EventBus.Subscribe)
+func (p *Peer) Subscribe(buffer int) (<-chan Event, func()) {
+	return p.BlockManager.Events().Subscribe(buffer)
+}
+
+// SubscribeFunc assina os eventos do peer por callback (ver EventBus.SubscribeFunc)
+func (p *Peer) SubscribeFunc(fn func(Event)) func() {
+	return p.BlockManager.Events().SubscribeFunc(fn)
 }
 
 // Wait aguarda o download ser concluído (apenas para leechers)
@@ -155,50 +410,156 @@ func (p *Peer) Wait() {
 	}
 }
 
-// waitForDownloadCompletion aguarda download e valida arquivo
+// waitForDownloadCompletion aguarda download e valida arquivo. Se a validação
+// falhar, reverifica os blocos e volta a baixar os divergentes.
 func (p *Peer) waitForDownloadCompletion() {
-	// Aguarda conclusão
-	p.Client.Wait()
+	for {
+		// Aguarda conclusão
+		p.Client.Wait()
 
-	elapsed := time.Since(p.startTime)
-	p.Logger.Printf("[PEER] Download concluído em %s", elapsed)
+		// Metadados lidos antes de conferir a conclusão: uma extensão aplicada
+		// depois disso não entra na validação (é baixada na próxima volta)
+		meta := p.CurrentMetadata()
+		if !p.BlockManager.IsDownloadComplete() {
+			// Bloco invalidado ou extensão aplicada logo após o fim dos workers:
+			// o reagendamento já os reiniciou
+			if state := p.State(); state != StateStopping && state != StateStopped && !p.Client.Detach() {
+				continue
+			}
+
+			// Workers encerrados por Stop ou sem vizinho que forneça os blocos
+			// faltantes. Um reagendamento posterior volta a acompanhar o download.
+			p.Logger.Printf("[PEER] Download interrompido (%d blocos faltantes)", p.BlockManager.GetMissingBlocksCount())
+			return
+		}
+
+		if err := p.setState(StateVerifying); err != nil {
+			return
+		}
+
+		elapsed := time.Since(p.startTime)
+		p.Logger.Printf("[PEER] Download concluído em %s", elapsed)
+
+		// Valida integridade do arquivo
+		p.Logger.Printf("[PEER] Validando integridade do arquivo...")
+		err := p.validateFile(meta)
+		if err == nil && p.BlockManager.GetStateCounts()[BlockCorrupt] > 0 {
+			err = fmt.Errorf("blocos corrompidos durante a validação")
+		}
+
+		if err == nil {
+			if p.setState(StateSeeding) != nil {
+				return
+			}
+
+			p.Logger.Printf("[PEER] ✓ Arquivo validado com sucesso!")
+			p.Logger.Printf("[PEER] Agora atuando como seeder")
+
+			// Conteúdo ao vivo ainda aberto: não há download concluído, apenas em dia
+			if meta := p.CurrentMetadata(); meta.IsLive() && !meta.Live.Final {
+				p.Logger.Printf("[PEER] Em dia com o conteúdo ao vivo (%s); aguardando extensões", liveStatus(meta))
+
+				// Extensão aplicada durante a validação
+				if !p.BlockManager.IsDownloadComplete() && p.compareAndSetState(StateSeeding, StateDownloading) {
+					p.Client.Resume()
+					continue
+				}
+				return
+			}
+
+			// Imprime estatísticas
+			p.printStats(meta, elapsed)
+
+			p.BlockManager.Events().Publish(Event{Type: EventDownloadComplete, BlockID: -1})
+			return
+		}
 
-	// Valida integridade do arquivo
-	p.Logger.Printf("[PEER] Validando integridade do arquivo...")
-	if err := p.validateFile(); err != nil {
 		p.Logger.Printf("[PEER] ERRO: Falha na validação: %v", err)
-		return
+		p.BlockManager.Events().Publish(Event{Type: EventValidationFailed, BlockID: -1, Err: err})
+
+		if p.setState(StateRepairing) != nil {
+			return
+		}
+
+		bad := p.repairBlocks(meta)
+		if len(bad) == 0 && !p.BlockManager.IsDownloadComplete() {
+			// Blocos novos do conteúdo ao vivo ainda faltam: volta a baixá-los
+			if p.setState(StateDownloading) != nil {
+				return
+			}
+			p.Client.Resume()
+			continue
+		}
+		if len(bad) == 0 {
+			// Todos os blocos conferem mas o arquivo não: metadados inconsistentes
+			p.Logger.Printf("[PEER] ERRO: Nenhum bloco divergente; arquivo não pode ser reparado")
+			return
+		}
+
+		if p.setState(StateDownloading) != nil {
+			return
+		}
+		for _, blockID := range bad {
+			p.Client.RequeueBlock(blockID)
+		}
 	}
+}
 
-	p.Logger.Printf("[PEER] ✓ Arquivo validado com sucesso!")
-	p.Logger.Printf("[PEER] Agora atuando como seeder")
+// handleCorruptBlock trata um bloco que o servidor encontrou corrompido no
+// disco: um peer que já atuava como seeder volta a baixar o bloco
+func (p *Peer) handleCorruptBlock(blockID int) {
+	switch p.State() {
+	case StateDownloading:
+		if p.Client.RequeueBlock(blockID) {
+			go p.waitForDownloadCompletion()
+		}
 
-	// Atualiza modo para seeder
-	p.Mode = ModeSeeder
+	case StateSeeding:
+		if !p.compareAndSetState(StateSeeding, StateRepairing) {
+			return
+		}
+		if p.setState(StateDownloading) != nil {
+			return
+		}
+		p.Client.RequeueBlock(blockID)
+		go p.waitForDownloadCompletion()
 
-	// Imprime estatísticas
-	p.printStats(elapsed)
+	default:
+		// Verificando ou reparando: o bloco corrompido é tratado pelo reparo
+	}
 }
 
-// validateFile valida a integridade do arquivo baixado
-func (p *Peer) validateFile() error {
-	// Valida tamanho
-	fileSize, err := checksum.GetFileSize(p.FilePath)
+// validateFile valida a integridade do arquivo (ou diretório) baixado. No
+// conteúdo ao vivo o arquivo pode já ter crescido além dos metadados
+// validados, então só o prefixo descrito por eles é lido.
+func (p *Peer) validateFile(meta *metadata.Metadata) error {
+	reader, err := p.storage.NewReader()
 	if err != nil {
-		return fmt.Errorf("erro ao obter tamanho do arquivo: %w", err)
+		return fmt.Errorf("erro ao abrir arquivo: %w", err)
 	}
+	defer reader.Close()
 
-	if fileSize != p.Metadata.FileSize {
-		return fmt.Errorf("tamanho incorreto: esperado %d, obtido %d", p.Metadata.FileSize, fileSize)
+	algorithm, err := meta.HashAlgorithm()
+	if err != nil {
+		return err
 	}
 
-	// Valida checksum do arquivo completo
-	valid, err := checksum.ValidateFileChecksum(p.FilePath, p.Metadata.FileHash)
+	// Valida checksum do conteúdo completo
+	var content io.Reader = reader
+	if meta.IsLive() {
+		content = io.LimitReader(reader, meta.FileSize)
+	}
+	fileHash, fileSize, err := algorithm.SumReader(content)
 	if err != nil {
 		return fmt.Errorf("erro ao validar checksum: %w", err)
 	}
 
-	if !valid {
+	// Valida tamanho
+	if fileSize != meta.FileSize {
+		return fmt.Errorf("tamanho incorreto: esperado %d, obtido %d", meta.FileSize, fileSize)
+	}
+
+	if fileHash != meta.FileHash {
 		return fmt.Errorf("checksum do arquivo não corresponde")
 	}
 
@@ -206,17 +567,25 @@ func (p *Peer) validateFile() error {
 }
 
 // printStats imprime estatísticas do download
-func (p *Peer) printStats(elapsed time.Duration) {
-	totalBytes := p.Metadata.FileSize
+func (p *Peer) printStats(meta *metadata.Metadata, elapsed time.Duration) {
+	totalBytes := meta.FileSize
 	throughputMBps := float64(totalBytes) / elapsed.Seconds() / 1024 / 1024
 
 	p.Logger.Printf("[PEER] ========== ESTATÍSTICAS ==========")
-	p.Logger.Printf("[PEER] Arquivo: %s", p.Metadata.FileName)
+	p.Logger.Printf("[PEER] Arquivo: %s", meta.FileName)
 	p.Logger.Printf("[PEER] Tamanho: %d bytes (%.2f MB)", totalBytes, float64(totalBytes)/1024/1024)
-	p.Logger.Printf("[PEER] Blocos: %d (tamanho: %d bytes)", p.Metadata.TotalBlocks, p.Metadata.BlockSize)
+	if meta.IsChunked() {
+		p.Logger.Printf("[PEER] Blocos: %d (tamanho variável, até %d bytes)", meta.TotalBlocks, meta.BlockSize)
+	} else {
+		p.Logger.Printf("[PEER] Blocos: %d (tamanho: %d bytes)", meta.TotalBlocks, meta.BlockSize)
+	}
+	if p.reused.blocks > 0 {
+		p.Logger.Printf("[PEER] Reaproveitados: %d blocos (%d bytes) da versão anterior", p.reused.blocks, p.reused.bytes)
+	}
 	p.Logger.Printf("[PEER] Tempo: %s", elapsed)
 	p.Logger.Printf("[PEER] Throughput: %.2f MB/s", throughputMBps)
-	p.Logger.Printf("[PEER] Checksum: %s", p.Metadata.FileHash)
+	p.Logger.Printf("[PEER] Checksum: %s", meta.FileHash)
+	p.Logger.Printf("[PEER] Info hash: %s", p.InfoHash)
 	p.Logger.Printf("[PEER] ====================================")
 }
 
@@ -232,30 +601,26 @@ func (p *Peer) IsDownloadComplete() bool {
 
 // GetStats retorna estatísticas do peer
 func (p *Peer) GetStats() map[string]interface{} {
-	return map[string]interface{}{
-		"peer_id":          p.ID,
-		"mode":             string(p.Mode),
-		"port":             p.Port,
-		"total_blocks":     p.BlockManager.GetTotalBlocks(),
-		"available_blocks": p.BlockManager.GetAvailableBlocksCount(),
-		"missing_blocks":   p.BlockManager.GetMissingBlocksCount(),
-		"progress":         p.GetProgress(),
-		"complete":         p.IsDownloadComplete(),
-	}
-}
-
-// createEmptyFile cria um arquivo vazio com tamanho específico
-func createEmptyFile(filePath string, size int64) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+	blockStates := make(map[string]int)
+	for state, n := range p.BlockManager.GetStateCounts() {
+		blockStates[state.String()] = n
 	}
-	defer file.Close()
 
-	// Define tamanho do arquivo
-	if err := file.Truncate(size); err != nil {
-		return err
+	return map[string]interface{}{
+		"peer_id":              p.ID,
+		"info_hash":            p.InfoHash,
+		"mode":                 string(p.CurrentMode()),
+		"state":                string(p.State()),
+		"port":                 p.Port,
+		"total_blocks":         p.BlockManager.GetTotalBlocks(),
+		"available_blocks":     p.BlockManager.GetAvailableBlocksCount(),
+		"missing_blocks":       p.BlockManager.GetMissingBlocksCount(),
+		"progress":             p.GetProgress(),
+		"complete":             p.IsDownloadComplete(),
+		"block_states":         blockStates,
+		"rejected_connections": p.Server.RejectedConnections(),
+		"busy_rejections":      p.Server.BusyRejections(),
+		"dropped_events":       p.BlockManager.Events().Dropped(),
+		"reused_bytes":         p.reused.bytes,
 	}
-
-	return nil
 }
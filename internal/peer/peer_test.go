@@ -0,0 +1,166 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+// unreachableAddress retorna um endereço local sem ninguém escutando
+func unreachableAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// writeTestContent grava um arquivo com o conteúdo informado e seus metadados
+// (blocos de 1KB) em dir; retorna os caminhos e os metadados
+func writeTestContent(t *testing.T, dir, name string, content []byte) (path, metaPath string, meta *metadata.Metadata) {
+	t.Helper()
+
+	path = filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Generate(path, metadata.GenerateOptions{BlockSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaPath = path + ".meta"
+	if err := meta.SaveToFile(metaPath); err != nil {
+		t.Fatal(err)
+	}
+	return path, metaPath, meta
+}
+
+func TestDownloadWaitReturnsWithoutNeighbors(t *testing.T) {
+	dir := t.TempDir()
+	_, metaPath, _ := writeTestContent(t, dir, "arquivo.bin", make([]byte, 4096))
+
+	p, err := NewPeer(PeerConfig{
+		ID:           "leecher",
+		Mode:         ModeLeecher,
+		MetadataPath: metaPath,
+		DownloadDir:  filepath.Join(dir, "download"),
+		Neighbors:    []NeighborInfo{{Address: unreachableAddress(t)}},
+		Logger:       log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.setState(StateDownloading); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Client.Start()
+	done := make(chan struct{})
+	go func() {
+		p.waitForDownloadCompletion()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("waitForDownloadCompletion não retornou com o único vizinho inacessível")
+	}
+	if state := p.State(); state != StateDownloading {
+		t.Fatalf("estado %s, esperado %s", state, StateDownloading)
+	}
+
+	// Reagendar um bloco reinicia os workers e avisa que ninguém os acompanha
+	if !p.Client.RequeueBlock(0) {
+		t.Fatal("RequeueBlock não informou o download abandonado")
+	}
+	p.Client.Stop(t.Context())
+}
+
+// exchange envia uma requisição pela conexão e retorna a resposta
+func exchange(t *testing.T, conn net.Conn, request protocol.Message) protocol.Message {
+	t.Helper()
+
+	if err := protocol.SendMessage(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	data, err := protocol.ReceiveMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestSharedServerRoutesByInfoHash(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+
+	pathA, metaPathA, metaA := writeTestContent(t, dir, "a.bin", bytes.Repeat([]byte("a"), 2048))
+	pathB, metaPathB, metaB := writeTestContent(t, dir, "b.bin", bytes.Repeat([]byte("b"), 5000))
+
+	owner, err := NewPeer(PeerConfig{ID: "daemon", Mode: ModeSeeder, FilePath: pathA, MetadataPath: metaPathA, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Stop(t.Context())
+
+	second, err := NewPeer(PeerConfig{ID: "daemon", Mode: ModeSeeder, FilePath: pathB, MetadataPath: metaPathB, Logger: logger, SharedServer: owner.Server})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", owner.Server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Sem info hash, a conexão começa no swarm principal
+	if info, ok := exchange(t, conn, protocol.NewRequestInfo()).(*protocol.PeerInfoMsg); !ok || info.TotalBlocks != metaA.TotalBlocks {
+		t.Fatalf("swarm principal: %+v", info)
+	}
+
+	// REQUEST_INFO com o info hash do segundo conteúdo direciona os blocos seguintes
+	request := protocol.NewRequestInfo()
+	request.InfoHash = second.InfoHash
+	if info, ok := exchange(t, conn, request).(*protocol.PeerInfoMsg); !ok || info.TotalBlocks != metaB.TotalBlocks {
+		t.Fatalf("segundo swarm: %+v", info)
+	}
+	block, ok := exchange(t, conn, protocol.NewRequestBlock(4)).(*protocol.BlockDataMsg)
+	if !ok || !bytes.Equal(block.Data, bytes.Repeat([]byte("b"), 5000-4*1024)) {
+		t.Fatalf("bloco 4 não veio do segundo conteúdo: %+v", block)
+	}
+
+	// Conteúdo encerrado deixa de ser servido, inclusive na conexão que o usava
+	if err := second.Stop(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if errMsg, ok := exchange(t, conn, protocol.NewRequestBlock(0)).(*protocol.ErrorMsg); !ok || errMsg.Code != protocol.ErrCodeUnknownSwarm {
+		t.Fatalf("bloco de swarm encerrado: esperado %s", protocol.ErrCodeUnknownSwarm)
+	}
+	if errMsg, ok := exchange(t, conn, request).(*protocol.ErrorMsg); !ok || errMsg.Code != protocol.ErrCodeUnknownSwarm {
+		t.Fatalf("REQUEST_INFO de swarm encerrado: esperado %s", protocol.ErrCodeUnknownSwarm)
+	}
+}
@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxSecureFrame é o maior payload cifrado em um único frame
+const maxSecureFrame = 64 * 1024
+
+// secureConn cifra o stream com AES-256-GCM após o handshake do swarm.
+// Cada Write vira um ou mais frames [4 bytes tamanho][dados cifrados]; o nonce
+// é um contador por direção, então frames reordenados ou repetidos são rejeitados.
+type secureConn struct {
+	net.Conn
+
+	writeMu  sync.Mutex
+	sendAEAD cipher.AEAD
+	sendSeq  uint64
+
+	readMu   sync.Mutex
+	recvAEAD cipher.AEAD
+	recvSeq  uint64
+	pending  []byte // texto claro já decifrado e ainda não lido
+}
+
+// newSecureConn deriva as chaves de sessão de cada direção a partir da chave
+// do swarm e da transcrição do handshake
+func newSecureConn(conn net.Conn, swarmKey, session []byte, isClient bool) (net.Conn, error) {
+	clientToServer, err := newSessionAEAD(swarmKey, session, "client->server")
+	if err != nil {
+		return nil, err
+	}
+	serverToClient, err := newSessionAEAD(swarmKey, session, "server->client")
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &secureConn{Conn: conn}
+	if isClient {
+		sc.sendAEAD, sc.recvAEAD = clientToServer, serverToClient
+	} else {
+		sc.sendAEAD, sc.recvAEAD = serverToClient, clientToServer
+	}
+
+	return sc, nil
+}
+
+// newSessionAEAD cria a cifra de uma direção do stream
+func newSessionAEAD(swarmKey, session []byte, direction string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, swarmKey)
+	mac.Write([]byte(direction))
+	mac.Write(session)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar cifra: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// nonce converte o contador de frames no nonce do GCM
+func frameNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// Write cifra e envia os dados
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxSecureFrame {
+			chunk = chunk[:maxSecureFrame]
+		}
+
+		sealed := c.sendAEAD.Seal(nil, frameNonce(c.sendAEAD, c.sendSeq), chunk, nil)
+		c.sendSeq++
+
+		frame := make([]byte, 4+len(sealed))
+		binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+		copy(frame[4:], sealed)
+
+		if _, err := c.Conn.Write(frame); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Read recebe e decifra os dados
+func (c *secureConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.pending) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxSecureFrame+uint32(c.recvAEAD.Overhead()) {
+			return 0, fmt.Errorf("frame cifrado muito grande: %d bytes", size)
+		}
+
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+			return 0, err
+		}
+
+		plain, err := c.recvAEAD.Open(nil, frameNonce(c.recvAEAD, c.recvSeq), sealed, nil)
+		if err != nil {
+			return 0, fmt.Errorf("frame cifrado inválido: %w", err)
+		}
+		c.recvSeq++
+		c.pending = plain
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
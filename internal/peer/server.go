@@ -1,36 +1,170 @@
 package peer
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/identity"
 	"github.com/zatta/tp2-p2p/internal/metadata"
 	"github.com/zatta/tp2-p2p/internal/protocol"
+	"github.com/zatta/tp2-p2p/internal/storage"
 )
 
+// Valores padrão de ServerOptions
+const (
+	DefaultIdleTimeout    = 2 * time.Minute
+	DefaultReadTimeout    = 30 * time.Second
+	DefaultWriteTimeout   = 30 * time.Second
+	DefaultMaxConnections = 256
+)
+
+// Tempo e volume máximos descartados ao recusar uma conexão com BUSY
+const (
+	busyLinger      = time.Second
+	busyLingerBytes = 64 * 1024
+)
+
+// Erros internos de registro de conexões
+var (
+	errServerStopping = errors.New("servidor parando")
+	errServerBusy     = errors.New("limite de conexões atingido")
+)
+
+// ServerOptions contém parâmetros opcionais do servidor (zero usa o padrão)
+type ServerOptions struct {
+	IdleTimeout    time.Duration // Espera máxima pela próxima requisição
+	ReadTimeout    time.Duration // Prazo para receber uma mensagem já iniciada
+	WriteTimeout   time.Duration // Prazo para enviar uma resposta
+	MaxConnections int           // Conexões simultâneas; excedentes recebem BUSY
+	AccessList     *AccessList   // Regras de acesso por IP (nil = todos)
+	TLSConfig      *tls.Config   // TLS com autenticação mútua (nil = TCP puro)
+
+	// Identity exige que clientes provem sua identidade Ed25519 no handshake
+	Identity *identity.Identity
+
+	// SwarmKey (ver DeriveSwarmKey) restringe o acesso a peers que conhecem o
+	// segredo do swarm; EncryptStream cifra o tráfego após o handshake
+	SwarmKey      []byte
+	EncryptStream bool
+}
+
+// withDefaults preenche campos não configurados com os valores padrão
+func (o ServerOptions) withDefaults() ServerOptions {
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = DefaultReadTimeout
+	}
+	if o.WriteTimeout <= 0 {
+		o.WriteTimeout = DefaultWriteTimeout
+	}
+	if o.MaxConnections <= 0 {
+		o.MaxConnections = DefaultMaxConnections
+	}
+	return o
+}
+
 // Server representa o servidor TCP do peer
 type Server struct {
-	port         int
-	listener     net.Listener
-	blockManager *BlockManager
-	metadata     *metadata.Metadata
-	filePath     string
-	logger       *log.Logger
-	stopChan     chan struct{}
+	port      int
+	options   ServerOptions
+	handshake handshakeConfig
+	listener  net.Listener
+	logger    *log.Logger
+	stopChan  chan struct{}
+
+	// Conteúdos servidos por info hash; primary é o criado com o servidor
+	primary  *swarm
+	swarms   map[string]*swarm
+	swarmsMu sync.RWMutex
+
+	// Conexões ativas: true indica que uma resposta está em andamento
+	conns    map[net.Conn]bool
+	connsMu  sync.Mutex
+	connWg   sync.WaitGroup
+	stopping bool
+
+	// Conexões recusadas por limite, ainda recebendo o aviso de BUSY
+	rejecting map[net.Conn]struct{}
+
+	// Regras de acesso (substituíveis em tempo de execução) e contadores
+	accessList    atomic.Pointer[AccessList]
+	rejectedConns atomic.Int64
+	busyRejected  atomic.Int64
+}
+
+// NewServer cria um novo servidor para o conteúdo descrito por meta (o swarm
+// principal); outros podem ser servidos na mesma porta (ver PeerConfig.SharedServer)
+func NewServer(port int, blockManager *BlockManager, meta *metadata.Metadata, store storage.Storage, logger *log.Logger, options ServerOptions) *Server {
+	primary := newSwarm(blockManager, meta, store, &BlockVerifier{meta: meta})
+	s := &Server{
+		port:    port,
+		options: options.withDefaults(),
+		handshake: handshakeConfig{
+			identity: options.Identity,
+			swarmKey: options.SwarmKey,
+			encrypt:  options.EncryptStream,
+		},
+		logger:    logger,
+		stopChan:  make(chan struct{}),
+		primary:   primary,
+		swarms:    map[string]*swarm{"": primary},
+		conns:     make(map[net.Conn]bool),
+		rejecting: make(map[net.Conn]struct{}),
+	}
+	s.accessList.Store(options.AccessList)
+
+	return s
 }
 
-// NewServer cria um novo servidor
-func NewServer(port int, blockManager *BlockManager, meta *metadata.Metadata, filePath string, logger *log.Logger) *Server {
-	return &Server{
-		port:         port,
-		blockManager: blockManager,
-		metadata:     meta,
-		filePath:     filePath,
-		logger:       logger,
-		stopChan:     make(chan struct{}),
+// SetAccessList substitui as regras de acesso; vale para as próximas conexões.
+// Regras "peer:<id>" são recusadas se o servidor não exige handshake.
+func (s *Server) SetAccessList(acl *AccessList) error {
+	if acl.HasPeerRules() && !s.handshake.enabled() {
+		return fmt.Errorf("regras de acesso \"peer:<id>\" requerem identity_key ou swarm_key")
 	}
+
+	s.accessList.Store(acl)
+	s.logger.Printf("[SERVER] Regras de acesso atualizadas")
+	return nil
+}
+
+// RejectedConnections retorna quantas conexões foram recusadas pelas regras de acesso
+func (s *Server) RejectedConnections() int64 {
+	return s.rejectedConns.Load()
+}
+
+// BusyRejections retorna quantas conexões foram recusadas por limite de conexões
+func (s *Server) BusyRejections() int64 {
+	return s.busyRejected.Load()
+}
+
+// SetBlockVerifier substitui o verificador de blocos do swarm principal
+// (obrigatório no modo Merkle)
+func (s *Server) SetBlockVerifier(v *BlockVerifier) {
+	s.primary.verifier = v
+}
+
+// SetInfoHash define o info hash do swarm principal: handshakes e pedidos que
+// citarem um conteúdo não servido são recusados com UNKNOWN_SWARM. Com chave do
+// swarm, a recusa só é informada a quem já provou conhecer a chave.
+func (s *Server) SetInfoHash(infoHash string) {
+	s.swarmsMu.Lock()
+	defer s.swarmsMu.Unlock()
+
+	delete(s.swarms, s.primary.infoHash)
+	s.primary.infoHash = infoHash
+	s.swarms[infoHash] = s.primary
 }
 
 // Start inicia o servidor TCP
@@ -40,6 +174,11 @@ func (s *Server) Start() error {
 		return fmt.Errorf("erro ao iniciar servidor na porta %d: %w", s.port, err)
 	}
 
+	if s.options.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.options.TLSConfig)
+		s.logger.Printf("[SERVER] TLS com autenticação mútua habilitado")
+	}
+
 	s.listener = listener
 	s.logger.Printf("[SERVER] Escutando na porta %d", s.port)
 
@@ -48,13 +187,142 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop para o servidor
-func (s *Server) Stop() {
+// Stop para o servidor. Conexões ociosas são fechadas imediatamente e as que
+// estão enviando uma resposta têm até o prazo de ctx para concluí-la; depois
+// disso são fechadas à força e ctx.Err() é retornado.
+func (s *Server) Stop(ctx context.Context) error {
+	s.connsMu.Lock()
+	if s.stopping {
+		s.connsMu.Unlock()
+		return nil
+	}
+	s.stopping = true
 	close(s.stopChan)
+
+	// Fecha conexões ociosas (bloqueadas aguardando requisição)
+	active := 0
+	for conn, busy := range s.conns {
+		if busy {
+			active++
+			continue
+		}
+		conn.Close()
+	}
+	// Avisos de BUSY pendentes não precisam ser concluídos
+	for conn := range s.rejecting {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+
 	if s.listener != nil {
 		s.listener.Close()
 	}
-	s.logger.Printf("[SERVER] Servidor parado")
+
+	if active > 0 {
+		s.logger.Printf("[SERVER] Aguardando %d respostas em andamento", active)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Printf("[SERVER] Servidor parado")
+		return nil
+	case <-ctx.Done():
+		// Prazo esgotado: fecha o que restou
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connsMu.Unlock()
+		<-done
+
+		s.logger.Printf("[SERVER] Servidor parado (conexões encerradas à força)")
+		return ctx.Err()
+	}
+}
+
+// trackConn registra uma nova conexão. Retorna errServerStopping se o servidor
+// está parando ou errServerBusy se o limite de conexões foi atingido; nesse
+// caso a conexão fica registrada até rejectBusy concluir o aviso.
+func (s *Server) trackConn(conn net.Conn) error {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.stopping {
+		return errServerStopping
+	}
+	if len(s.conns) >= s.options.MaxConnections {
+		s.rejecting[conn] = struct{}{}
+		s.connWg.Add(1)
+		return errServerBusy
+	}
+
+	s.conns[conn] = false
+	s.connWg.Add(1)
+	return nil
+}
+
+// rejectBusy informa ao cliente que o servidor está lotado e fecha a conexão
+func (s *Server) rejectBusy(conn net.Conn) {
+	defer s.untrackRejected(conn)
+	defer conn.Close()
+
+	s.logger.Printf("[SERVER] Conexão de %s recusada: limite de %d conexões atingido",
+		conn.RemoteAddr(), s.options.MaxConnections)
+	errMsg := protocol.NewErrorWithCode(protocol.ErrCodeBusy, "Servidor ocupado, tente novamente mais tarde")
+	if err := s.send(conn, errMsg); err != nil {
+		return
+	}
+
+	// Descarta a requisição já enviada pelo cliente por um breve período, para
+	// que ele leia o aviso em vez de receber um reset da conexão
+	conn.SetReadDeadline(time.Now().Add(busyLinger))
+	io.Copy(io.Discard, io.LimitReader(conn, busyLingerBytes))
+}
+
+// send envia uma mensagem respeitando o prazo de escrita
+func (s *Server) send(conn net.Conn, msg protocol.Message) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout)); err != nil {
+		return err
+	}
+	return protocol.SendMessage(conn, msg)
+}
+
+// untrackConn remove uma conexão do registro
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+
+	s.connWg.Done()
+}
+
+// untrackRejected remove do registro uma conexão recusada com BUSY
+func (s *Server) untrackRejected(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.rejecting, conn)
+	s.connsMu.Unlock()
+
+	s.connWg.Done()
+}
+
+// setConnBusy marca a conexão como processando (ou não) uma requisição.
+// Retorna false se o servidor está parando e a conexão deve ser encerrada.
+func (s *Server) setConnBusy(conn net.Conn, busy bool) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.stopping {
+		return false
+	}
+
+	s.conns[conn] = busy
+	return true
 }
 
 // acceptConnections aceita novas conexões em loop
@@ -75,6 +343,24 @@ func (s *Server) acceptConnections() {
 				}
 			}
 
+			// Regras de acesso são aplicadas antes de qualquer tráfego do protocolo
+			if ip := remoteIP(conn.RemoteAddr()); !s.accessList.Load().Allows(ip) {
+				s.rejectedConns.Add(1)
+				s.logger.Printf("[SERVER] Conexão de %s negada pelas regras de acesso", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+
+			if err := s.trackConn(conn); err != nil {
+				if err == errServerBusy {
+					s.busyRejected.Add(1)
+					go s.rejectBusy(conn)
+					continue
+				}
+				conn.Close()
+				return
+			}
+
 			// Processa conexão em goroutine separada
 			go s.handleConnection(conn)
 		}
@@ -83,98 +369,318 @@ func (s *Server) acceptConnections() {
 
 // handleConnection trata uma conexão de cliente
 func (s *Server) handleConnection(conn net.Conn) {
+	defer s.untrackConn(conn)
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	s.logger.Printf("[SERVER] Nova conexão de %s", remoteAddr)
+
+	// Conclui o handshake TLS antes de qualquer mensagem do protocolo
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.options.ReadTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger.Printf("[SERVER] Handshake TLS com %s falhou: %v", remoteAddr, err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+
+		s.logger.Printf("[SERVER] Nova conexão TLS de %s (certificado: %s)", remoteAddr, peerCertificateName(tlsConn))
+	} else {
+		s.logger.Printf("[SERVER] Nova conexão de %s", remoteAddr)
+	}
+
+	// Com identidade ou chave do swarm configurada, nenhuma requisição é
+	// atendida antes do handshake; stream pode passar a ser cifrado. O swarm
+	// da conexão é o pedido no HELLO ou, sem handshake, o principal até um
+	// REQUEST_INFO informar outro.
+	stream := conn
+	sw := s.primary
+	if s.handshake.enabled() {
+		authStream, peerID, authSwarm, err := s.authenticate(conn)
+		if err != nil {
+			s.logger.Printf("[SERVER] Autenticação de %s falhou: %v", remoteAddr, err)
+			return
+		}
+		stream = authStream
+		sw = authSwarm
+
+		if peerID != "" {
+			s.logger.Printf("[SERVER] Peer %s autenticado (%s)", peerID, remoteAddr)
+			remoteAddr = fmt.Sprintf("%s/%s", peerID, remoteAddr)
+		} else {
+			s.logger.Printf("[SERVER] Peer %s autenticado no swarm", remoteAddr)
+		}
+	}
 
 	// Loop para receber múltiplas requisições na mesma conexão
 	for {
 		// Recebe mensagem
-		msgData, err := protocol.ReceiveMessage(conn)
+		msgData, err := protocol.ReceiveMessageWithDeadlines(stream, s.options.IdleTimeout, s.options.ReadTimeout)
 		if err != nil {
-			// Conexão fechada ou erro
-			s.logger.Printf("[SERVER] Conexão fechada por %s", remoteAddr)
+			// Conexão fechada, ociosa por tempo demais ou erro
+			if isTimeout(err) {
+				s.logger.Printf("[SERVER] Conexão com %s encerrada por inatividade", remoteAddr)
+			} else {
+				s.logger.Printf("[SERVER] Conexão fechada por %s", remoteAddr)
+			}
 			return
 		}
 
-		// Parse mensagem
-		msg, err := protocol.ParseMessage(msgData)
-		if err != nil {
-			s.logger.Printf("[SERVER] Erro ao parsear mensagem de %s: %v", remoteAddr, err)
-			errMsg := protocol.NewError(fmt.Sprintf("Erro ao parsear mensagem: %v", err))
-			protocol.SendMessage(conn, errMsg)
-			continue
+		// Requisições recebidas durante o desligamento são descartadas
+		if !s.setConnBusy(conn, true) {
+			return
 		}
 
-		// Processa baseado no tipo
-		switch m := msg.(type) {
-		case *protocol.RequestInfoMsg:
-			s.handleRequestInfo(conn, remoteAddr)
+		sw = s.handleMessage(stream, remoteAddr, sw, msgData)
 
-		case *protocol.RequestBlockMsg:
-			s.handleRequestBlock(conn, remoteAddr, m.BlockID)
+		// Resposta concluída: encerra se o servidor estiver parando
+		if !s.setConnBusy(conn, false) {
+			return
+		}
+	}
+}
 
-		default:
-			s.logger.Printf("[SERVER] Tipo de mensagem desconhecido de %s", remoteAddr)
-			errMsg := protocol.NewError("Tipo de mensagem não suportado")
-			protocol.SendMessage(conn, errMsg)
+// authenticate exige que a primeira mensagem da conexão seja um HELLO e
+// conduz o handshake; retorna o stream a usar, o ID verificado do cliente e o
+// swarm pedido
+func (s *Server) authenticate(conn net.Conn) (net.Conn, string, *swarm, error) {
+	msgData, err := protocol.ReceiveMessageWithDeadlines(conn, s.options.ReadTimeout, s.options.ReadTimeout)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("erro ao parsear mensagem: %w", err)
+	}
+
+	hello, ok := msg.(*protocol.HelloMsg)
+	if !ok {
+		s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeAuthRequired, "Handshake obrigatório"))
+		return nil, "", nil, fmt.Errorf("requisição %s sem handshake", msg.GetType())
+	}
+
+	stream, peerID, sw, err := s.serverHandshake(conn, hello)
+	if err != nil {
+		if errors.Is(err, errUnknownSwarm) {
+			s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeUnknownSwarm, "Conteúdo não servido por este peer"))
+		} else {
+			s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeAuthFailed, "Falha na autenticação"))
+		}
+		return nil, "", nil, err
+	}
+
+	return stream, peerID, sw, nil
+}
+
+// handleMessage processa uma requisição recebida no swarm atual da conexão e
+// retorna o swarm dos próximos pedidos
+func (s *Server) handleMessage(conn net.Conn, remoteAddr string, sw *swarm, msgData map[string]interface{}) *swarm {
+	// Parse mensagem
+	msg, err := protocol.ParseMessage(msgData)
+	if err != nil {
+		s.logger.Printf("[SERVER] Erro ao parsear mensagem de %s: %v", remoteAddr, err)
+		errMsg := protocol.NewError(fmt.Sprintf("Erro ao parsear mensagem: %v", err))
+		s.send(conn, errMsg)
+		return sw
+	}
+
+	// Processa baseado no tipo
+	switch m := msg.(type) {
+	case *protocol.RequestInfoMsg:
+		// Os blocos pedidos a seguir são os do swarm consultado
+		if routed := s.handleRequestInfo(conn, remoteAddr, sw, m); routed != nil {
+			sw = routed
 		}
+
+	case *protocol.RequestBlockMsg:
+		s.handleRequestBlock(conn, remoteAddr, sw, m.BlockID)
+
+	case *protocol.RequestMetadataMsg:
+		s.handleRequestMetadata(conn, remoteAddr, m)
+
+	case *protocol.RequestExtensionMsg:
+		s.handleRequestExtension(conn, remoteAddr, m)
+
+	default:
+		s.logger.Printf("[SERVER] Tipo de mensagem desconhecido de %s", remoteAddr)
+		errMsg := protocol.NewError("Tipo de mensagem não suportado")
+		s.send(conn, errMsg)
 	}
+
+	return sw
 }
 
-// handleRequestInfo responde com informações sobre blocos disponíveis
-func (s *Server) handleRequestInfo(conn net.Conn, remoteAddr string) {
-	availableBlocks := s.blockManager.GetAvailableBlocks()
-	totalBlocks := s.blockManager.GetTotalBlocks()
+// handleRequestInfo responde com informações sobre blocos disponíveis, na
+// codificação mais compacta entre as aceitas pelo cliente. Retorna o swarm
+// consultado (nil se não servido).
+func (s *Server) handleRequestInfo(conn net.Conn, remoteAddr string, current *swarm, request *protocol.RequestInfoMsg) *swarm {
+	// Com chave do swarm, o cliente chegou aqui só depois de provar a posse dela
+	sw := s.routeSwarm(current, request.InfoHash)
+	if sw == nil {
+		s.logger.Printf("[SERVER] REQUEST_INFO de %s para swarm desconhecido %s", remoteAddr, request.InfoHash)
+		s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeUnknownSwarm, "Conteúdo não servido por este peer"))
+		return nil
+	}
 
-	s.logger.Printf("[SERVER] REQUEST_INFO de %s - Disponíveis: %d/%d", remoteAddr, len(availableBlocks), totalBlocks)
+	available := sw.blockManager.GetAvailabilitySnapshot()
+	response := protocol.NewPeerInfoEncoded(available, request.Encodings)
 
-	response := protocol.NewPeerInfo(availableBlocks, totalBlocks)
-	if err := protocol.SendMessage(conn, response); err != nil {
+	encoding := response.Encoding
+	if encoding == "" {
+		encoding = protocol.AvailabilityList
+	}
+	s.logger.Printf("[SERVER] REQUEST_INFO de %s - Disponíveis: %d/%d (%s)",
+		remoteAddr, available.Count(), available.Len(), encoding)
+
+	if err := s.send(conn, response); err != nil {
 		s.logger.Printf("[SERVER] Erro ao enviar PEER_INFO para %s: %v", remoteAddr, err)
 	}
+	return sw
 }
 
-// handleRequestBlock responde com dados do bloco solicitado
-func (s *Server) handleRequestBlock(conn net.Conn, remoteAddr string, blockID int) {
+// handleRequestMetadata envia uma parte dos metadados a um peer que só
+// conhece o info hash
+func (s *Server) handleRequestMetadata(conn net.Conn, remoteAddr string, request *protocol.RequestMetadataMsg) {
+	sw := s.lookupSwarm(request.InfoHash)
+	if request.InfoHash == "" || sw == nil {
+		s.logger.Printf("[SERVER] REQUEST_METADATA de %s para swarm desconhecido %s", remoteAddr, request.InfoHash)
+		s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeUnknownSwarm, "Conteúdo não servido por este peer"))
+		return
+	}
+
+	encoded, err := sw.encodeMetadata()
+	if err != nil {
+		s.logger.Printf("[SERVER] Erro ao serializar metadados: %v", err)
+		s.send(conn, protocol.NewError("Erro ao serializar metadados"))
+		return
+	}
+
+	// Confere a parte antes de multiplicar: um índice enorme estouraria o int
+	total := len(encoded)
+	pieces := (total + protocol.MetadataPieceSize - 1) / protocol.MetadataPieceSize
+	if request.Piece < 0 || request.Piece >= pieces {
+		s.send(conn, protocol.NewError(fmt.Sprintf("Parte %d dos metadados não existe", request.Piece)))
+		return
+	}
+	start := request.Piece * protocol.MetadataPieceSize
+	end := min(start+protocol.MetadataPieceSize, total)
+
+	s.logger.Printf("[SERVER] REQUEST_METADATA %d de %s (%d-%d de %d bytes)", request.Piece, remoteAddr, start, end, total)
+
+	response := protocol.NewMetadata(request.InfoHash, request.Piece, total, encoded[start:end])
+	if err := s.send(conn, response); err != nil {
+		s.logger.Printf("[SERVER] Erro ao enviar METADATA para %s: %v", remoteAddr, err)
+	}
+}
+
+// handleRequestExtension envia os blocos de conteúdo ao vivo publicados
+// depois da extensão que o cliente já conhece
+func (s *Server) handleRequestExtension(conn net.Conn, remoteAddr string, request *protocol.RequestExtensionMsg) {
+	sw := s.lookupSwarm(request.InfoHash)
+	if sw == nil {
+		s.logger.Printf("[SERVER] REQUEST_EXTENSION de %s para swarm desconhecido %s", remoteAddr, request.InfoHash)
+		s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeUnknownSwarm, "Conteúdo não servido por este peer"))
+		return
+	}
+
+	meta := sw.currentMetadata()
+	if !meta.IsLive() {
+		s.send(conn, protocol.NewError("Conteúdo não é ao vivo"))
+		return
+	}
+
+	// Vizinho já tem a extensão atual (ou uma mais nova)
+	if request.Seq >= meta.Live.Seq {
+		s.send(conn, protocol.NewExtension(request.InfoHash, nil))
+		return
+	}
+
+	ext, err := meta.ExtensionFrom(request.FirstBlock)
+	if err != nil {
+		s.send(conn, protocol.NewError(fmt.Sprintf("Extensão indisponível: %v", err)))
+		return
+	}
+	data, err := json.Marshal(ext)
+	if err != nil {
+		s.logger.Printf("[SERVER] Erro ao serializar extensão: %v", err)
+		s.send(conn, protocol.NewError("Erro ao serializar extensão"))
+		return
+	}
+
+	s.logger.Printf("[SERVER] REQUEST_EXTENSION de %s - Extensão %d: blocos %d-%d",
+		remoteAddr, ext.Seq, ext.FirstBlock, meta.TotalBlocks-1)
+
+	if err := s.send(conn, protocol.NewExtension(request.InfoHash, data)); err != nil {
+		s.logger.Printf("[SERVER] Erro ao enviar EXTENSION para %s: %v", remoteAddr, err)
+	}
+}
+
+// handleRequestBlock responde com dados do bloco solicitado do swarm da conexão
+func (s *Server) handleRequestBlock(conn net.Conn, remoteAddr string, current *swarm, blockID int) {
+	// O swarm pode ter deixado de ser servido desde o REQUEST_INFO
+	sw := s.routeSwarm(current, "")
+	if sw == nil {
+		s.logger.Printf("[SERVER] REQUEST_BLOCK %d de %s - Swarm não é mais servido", blockID, remoteAddr)
+		s.send(conn, protocol.NewErrorWithCode(protocol.ErrCodeUnknownSwarm, "Conteúdo não servido por este peer"))
+		return
+	}
+
 	// Verifica se o bloco está disponível
-	if !s.blockManager.IsBlockAvailable(blockID) {
+	if !sw.blockManager.IsBlockAvailable(blockID) {
 		s.logger.Printf("[SERVER] REQUEST_BLOCK %d de %s - Bloco não disponível", blockID, remoteAddr)
-		errMsg := protocol.NewError(fmt.Sprintf("Bloco %d não disponível", blockID))
-		protocol.SendMessage(conn, errMsg)
+		errMsg := protocol.NewErrorWithCode(protocol.ErrCodeBlockUnavailable, fmt.Sprintf("Bloco %d não disponível", blockID))
+		s.send(conn, errMsg)
 		return
 	}
 
 	// Lê bloco do arquivo
-	blockData, err := checksum.ReadBlockFromFile(s.filePath, blockID, s.metadata.BlockSize)
+	blockData, err := sw.storage.ReadBlock(blockID)
 	if err != nil {
 		s.logger.Printf("[SERVER] Erro ao ler bloco %d: %v", blockID, err)
 		errMsg := protocol.NewError(fmt.Sprintf("Erro ao ler bloco: %v", err))
-		protocol.SendMessage(conn, errMsg)
+		s.send(conn, errMsg)
 		return
 	}
 
-	// Calcula checksum do bloco
-	blockChecksum := checksum.CalculateBlockChecksum(blockData)
+	// Valida com os metadados (hash do bloco ou folha da árvore de Merkle)
+	if err := sw.verifier.VerifyLocal(blockID, blockData); err != nil {
+		if !errors.Is(err, errBlockMismatch) {
+			s.logger.Printf("[SERVER] Erro ao verificar bloco %d: %v", blockID, err)
+			errMsg := protocol.NewError("Erro ao obter metadados do bloco")
+			s.send(conn, errMsg)
+			return
+		}
+
+		// Nunca repassa dados corrompidos: retira o bloco de circulação e avisa o cliente
+		s.logger.Printf("[SERVER] ERRO: Checksum do bloco %d não corresponde aos metadados - bloco marcado como indisponível", blockID)
+		sw.blockManager.MarkBlockCorrupt(blockID)
+		sw.blockManager.Events().Publish(Event{
+			Type:    EventBlockFailed,
+			BlockID: blockID,
+			Err:     fmt.Errorf("bloco %d corrompido no disco local", blockID),
+		})
+		if sw.onCorruptBlock != nil {
+			sw.onCorruptBlock(blockID)
+		}
 
-	// Valida com checksum dos metadados
-	expectedBlock, err := s.metadata.GetBlock(blockID)
-	if err != nil {
-		s.logger.Printf("[SERVER] Erro ao obter metadados do bloco %d: %v", blockID, err)
-		errMsg := protocol.NewError("Erro ao obter metadados do bloco")
-		protocol.SendMessage(conn, errMsg)
+		errMsg := protocol.NewErrorWithCode(protocol.ErrCodeBlockCorrupt, fmt.Sprintf("Bloco %d corrompido no peer", blockID))
+		s.send(conn, errMsg)
 		return
 	}
 
-	if blockChecksum != expectedBlock.Hash {
-		s.logger.Printf("[SERVER] AVISO: Checksum do bloco %d não corresponde aos metadados", blockID)
+	proof, err := sw.verifier.Proof(blockID)
+	if err != nil {
+		s.logger.Printf("[SERVER] Erro ao gerar prova do bloco %d: %v", blockID, err)
+		errMsg := protocol.NewErrorWithCode(protocol.ErrCodeBlockUnavailable, fmt.Sprintf("Bloco %d sem prova disponível", blockID))
+		s.send(conn, errMsg)
+		return
 	}
 
 	// Envia bloco
 	s.logger.Printf("[SERVER] Enviando bloco %d (%d bytes) para %s", blockID, len(blockData), remoteAddr)
-	response := protocol.NewBlockData(blockID, blockData, blockChecksum)
-	if err := protocol.SendMessage(conn, response); err != nil {
+	response := protocol.NewBlockData(blockID, blockData, sw.blockChecksum(blockData))
+	response.Proof = proof
+	if err := s.send(conn, response); err != nil {
 		s.logger.Printf("[SERVER] Erro ao enviar BLOCK_DATA para %s: %v", remoteAddr, err)
 	}
 }
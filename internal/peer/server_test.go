@@ -0,0 +1,127 @@
+package peer
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/protocol"
+)
+
+// serveMetadataPiece envia REQUEST_METADATA ao handler e retorna a resposta
+func serveMetadataPiece(t *testing.T, s *Server, infoHash string, piece int) protocol.Message {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go s.handleRequestMetadata(serverConn, "teste", &protocol.RequestMetadataMsg{
+		Type:     protocol.MsgTypeRequestMetadata,
+		InfoHash: infoHash,
+		Piece:    piece,
+	})
+
+	data, err := protocol.ReceiveMessage(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestRequestMetadataPieceBounds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arquivo.bin")
+	if err := os.WriteFile(path, make([]byte, 10000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Generate(path, metadata.GenerateOptions{BlockSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash, err := meta.InfoHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(0, NewBlockManager(meta.TotalBlocks), meta, nil, log.New(io.Discard, "", 0), ServerOptions{})
+	s.SetInfoHash(infoHash)
+
+	if _, ok := serveMetadataPiece(t, s, infoHash, 0).(*protocol.MetadataMsg); !ok {
+		t.Fatal("parte 0 dos metadados não foi servida")
+	}
+
+	// Índices cujo deslocamento estoura o int não podem derrubar o servidor
+	for _, piece := range []int{-1, 1, 52776558133248, math.MaxInt} {
+		if _, ok := serveMetadataPiece(t, s, infoHash, piece).(*protocol.ErrorMsg); !ok {
+			t.Fatalf("parte %d: esperado ERROR", piece)
+		}
+	}
+}
+
+func TestRequestMetadataRequiresInfoHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arquivo.bin")
+	if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Generate(path, metadata.GenerateOptions{BlockSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Servidor sem info hash definido não serve metadados a pedidos vazios
+	s := NewServer(0, NewBlockManager(meta.TotalBlocks), meta, nil, log.New(io.Discard, "", 0), ServerOptions{})
+	if msg, ok := serveMetadataPiece(t, s, "", 0).(*protocol.ErrorMsg); !ok || msg.Code != protocol.ErrCodeUnknownSwarm {
+		t.Fatalf("pedido sem info hash: %+v", msg)
+	}
+}
+
+func TestStopWaitsForBusyRejection(t *testing.T) {
+	s := NewServer(0, NewBlockManager(1), nil, nil, log.New(io.Discard, "", 0), ServerOptions{MaxConnections: 1})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := s.listener.Addr().String()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	data, err := protocol.ReceiveMessage(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := protocol.ParseMessage(data); err != nil || errorCode(msg.(*protocol.ErrorMsg)) != protocol.ErrCodeBusy {
+		t.Fatalf("esperado BUSY, recebido %v (%v)", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Após Stop, a conexão recusada já foi fechada pelo servidor
+	second.SetReadDeadline(time.Now().Add(busyLinger / 4))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("conexão recusada ainda aberta após Stop: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package peer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+// swarm reúne o que o servidor precisa para servir um conteúdo. Um servidor
+// pode servir vários, escolhidos pelo info hash pedido na conexão.
+type swarm struct {
+	infoHash     string // vazio = não definido (aceita qualquer pedido)
+	blockManager *BlockManager
+	storage      storage.Storage
+
+	// verifier confere os blocos lidos do disco e fornece provas de Merkle
+	verifier *BlockVerifier
+
+	// onCorruptBlock é chamado quando um bloco local falha na verificação
+	onCorruptBlock func(blockID int)
+
+	// Metadados atuais (substituídos quando conteúdo ao vivo cresce) e sua
+	// serialização para REQUEST_METADATA, feita uma vez por versão
+	metaMu          sync.Mutex
+	metadata        *metadata.Metadata
+	encodedMetadata []byte
+}
+
+// newSwarm cria o swarm de um conteúdo
+func newSwarm(blockManager *BlockManager, meta *metadata.Metadata, store storage.Storage, verifier *BlockVerifier) *swarm {
+	return &swarm{
+		blockManager: blockManager,
+		storage:      store,
+		verifier:     verifier,
+		metadata:     meta,
+	}
+}
+
+// setMetadata passa a servir metadados estendidos (conteúdo ao vivo)
+func (sw *swarm) setMetadata(meta *metadata.Metadata) {
+	sw.metaMu.Lock()
+	defer sw.metaMu.Unlock()
+
+	sw.metadata = meta
+	sw.encodedMetadata = nil
+}
+
+// currentMetadata retorna os metadados servidos no momento
+func (sw *swarm) currentMetadata() *metadata.Metadata {
+	sw.metaMu.Lock()
+	defer sw.metaMu.Unlock()
+
+	return sw.metadata
+}
+
+// encodeMetadata serializa os metadados atuais, reaproveitando o resultado
+// até a próxima setMetadata
+func (sw *swarm) encodeMetadata() ([]byte, error) {
+	sw.metaMu.Lock()
+	defer sw.metaMu.Unlock()
+
+	if sw.encodedMetadata == nil {
+		data, err := sw.metadata.Encode()
+		if err != nil {
+			return nil, err
+		}
+		sw.encodedMetadata = data
+	}
+
+	return sw.encodedMetadata, nil
+}
+
+// blockChecksum calcula o checksum de transporte de um bloco com o algoritmo
+// escolhido na geração dos metadados
+func (sw *swarm) blockChecksum(data []byte) string {
+	algorithm, err := sw.currentMetadata().HashAlgorithm()
+	if err != nil {
+		return checksum.CalculateBlockChecksum(data)
+	}
+	return algorithm.Sum(data)
+}
+
+// addSwarm passa a servir outro conteúdo na mesma porta
+func (s *Server) addSwarm(sw *swarm) error {
+	if sw.infoHash == "" {
+		return fmt.Errorf("swarm sem info hash")
+	}
+
+	s.swarmsMu.Lock()
+	defer s.swarmsMu.Unlock()
+
+	if _, exists := s.swarms[sw.infoHash]; exists {
+		return fmt.Errorf("swarm %s já servido", sw.infoHash)
+	}
+	s.swarms[sw.infoHash] = sw
+
+	s.logger.Printf("[SERVER] Servindo swarm %s", sw.infoHash)
+	return nil
+}
+
+// removeSwarm deixa de servir um conteúdo; conexões que já o usavam passam a
+// receber UNKNOWN_SWARM no próximo pedido
+func (s *Server) removeSwarm(sw *swarm) {
+	s.swarmsMu.Lock()
+	defer s.swarmsMu.Unlock()
+
+	if s.swarms[sw.infoHash] == sw {
+		delete(s.swarms, sw.infoHash)
+	}
+}
+
+// lookupSwarm retorna o swarm registrado exatamente sob infoHash (ou nil)
+func (s *Server) lookupSwarm(infoHash string) *swarm {
+	s.swarmsMu.RLock()
+	defer s.swarmsMu.RUnlock()
+
+	return s.swarms[infoHash]
+}
+
+// routeSwarm escolhe o swarm de um pedido: o informado pelo cliente ou, sem
+// info hash, o atual da conexão. Servidor cujo swarm principal não tem info
+// hash definido aceita qualquer pedido.
+func (s *Server) routeSwarm(current *swarm, requested string) *swarm {
+	if requested == "" {
+		if current != nil && s.lookupSwarm(current.infoHash) == current {
+			return current
+		}
+		return nil
+	}
+
+	if sw := s.lookupSwarm(requested); sw != nil {
+		return sw
+	}
+	if s.primary.infoHash == "" {
+		return s.primary
+	}
+	return nil
+}
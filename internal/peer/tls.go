@@ -0,0 +1,90 @@
+package peer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions contém os arquivos usados para TLS com autenticação mútua
+type TLSOptions struct {
+	CertFile string // Certificado do peer (PEM)
+	KeyFile  string // Chave privada do certificado (PEM)
+	CAFile   string // CAs aceitas para autenticar outros peers (PEM)
+}
+
+// LoadTLSConfig carrega certificados e retorna as configurações TLS do
+// servidor e do cliente. Ambos os lados exigem certificado assinado por uma
+// das CAs do bundle; o nome do host não é verificado, pois peers são
+// endereçados por IP e identificados pelo certificado.
+func LoadTLSConfig(opts TLSOptions) (serverConfig *tls.Config, clientConfig *tls.Config, err error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("erro ao carregar certificado: %w", err)
+	}
+
+	caData, err := os.ReadFile(opts.CAFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("erro ao ler bundle de CAs: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, nil, fmt.Errorf("nenhum certificado válido em %s", opts.CAFile)
+	}
+
+	serverConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	clientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+		// A verificação padrão exige nome de host; a cadeia é validada em
+		// verifyPeerChain contra o mesmo pool de CAs
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyPeerChain(cs, pool)
+		},
+	}
+
+	return serverConfig, clientConfig, nil
+}
+
+// verifyPeerChain valida o certificado do servidor contra as CAs configuradas
+func verifyPeerChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer não apresentou certificado")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("certificado do peer não confiável: %w", err)
+	}
+
+	return nil
+}
+
+// peerCertificateName retorna o CN do certificado apresentado na conexão TLS
+func peerCertificateName(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return certs[0].Subject.CommonName
+}
@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+	"github.com/zatta/tp2-p2p/internal/merkle"
+	"github.com/zatta/tp2-p2p/internal/metadata"
+	"github.com/zatta/tp2-p2p/internal/storage"
+)
+
+// errBlockMismatch indica que os dados do bloco não conferem com os metadados
+var errBlockMismatch = errors.New("bloco não confere com os metadados")
+
+// BlockVerifier confere blocos contra os metadados: pelo hash listado para
+// cada bloco ou, no modo Merkle, pela prova até a raiz. Cliente e servidor de
+// um peer compartilham o mesmo verificador, de modo que as provas recebidas
+// ao baixar um bloco podem ser repassadas a quem pedir o bloco depois.
+type BlockVerifier struct {
+	mu   sync.Mutex
+	meta *metadata.Metadata // substituído quando conteúdo ao vivo cresce
+	tree *merkle.Tree       // nil fora do modo Merkle
+}
+
+// NewBlockVerifier cria o verificador dos metadados. No modo Merkle a árvore
+// começa conhecendo apenas a raiz (ver LoadTree para seeders).
+func NewBlockVerifier(meta *metadata.Metadata) (*BlockVerifier, error) {
+	v := &BlockVerifier{meta: meta}
+	if !meta.IsMerkle() {
+		return v, nil
+	}
+
+	root, err := meta.MerkleRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := merkle.NewPartial(meta.TotalBlocks, root)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar árvore de Merkle: %w", err)
+	}
+	v.tree = tree
+
+	return v, nil
+}
+
+// LoadTree monta a árvore completa a partir do conteúdo local e confere a raiz
+// com os metadados (necessário para um seeder gerar provas de todos os blocos)
+func (v *BlockVerifier) LoadTree(store storage.Storage) error {
+	if v.tree == nil {
+		return nil
+	}
+
+	tree, err := metadata.BuildMerkleTree(store, v.meta.TotalBlocks)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !bytes.Equal(tree.Root(), v.tree.Root()) {
+		return fmt.Errorf("raiz de Merkle do arquivo não confere com os metadados")
+	}
+	v.tree = tree
+
+	return nil
+}
+
+// SetMetadata passa a conferir os blocos contra metadados estendidos
+// (conteúdo ao vivo; não se aplica ao modo Merkle)
+func (v *BlockVerifier) SetMetadata(meta *metadata.Metadata) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.meta = meta
+}
+
+// VerifyReceived confere um bloco recebido de um vizinho, com a prova de
+// Merkle quando aplicável
+func (v *BlockVerifier) VerifyReceived(blockID int, data []byte, proof [][]byte) error {
+	if v.tree == nil {
+		return v.verifyHash(blockID, data)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.tree.Verify(blockID, merkle.LeafHash(data), proof); err != nil {
+		return fmt.Errorf("%w: %v", errBlockMismatch, err)
+	}
+
+	return nil
+}
+
+// VerifyLocal confere um bloco lido do disco local
+func (v *BlockVerifier) VerifyLocal(blockID int, data []byte) error {
+	if v.tree == nil {
+		return v.verifyHash(blockID, data)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	leaf, ok := v.tree.Leaf(blockID)
+	if !ok {
+		return fmt.Errorf("%w: folha %d ainda não verificada", errBlockMismatch, blockID)
+	}
+	if !bytes.Equal(leaf, merkle.LeafHash(data)) {
+		return errBlockMismatch
+	}
+
+	return nil
+}
+
+// Proof retorna a prova de Merkle do bloco (nil fora do modo Merkle)
+func (v *BlockVerifier) Proof(blockID int) ([][]byte, error) {
+	if v.tree == nil {
+		return nil, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.tree.Proof(blockID)
+}
+
+// verifyHash compara o checksum dos dados com o hash listado nos metadados
+func (v *BlockVerifier) verifyHash(blockID int, data []byte) error {
+	v.mu.Lock()
+	meta := v.meta
+	v.mu.Unlock()
+
+	expectedBlock, err := meta.GetBlock(blockID)
+	if err != nil {
+		return fmt.Errorf("erro ao obter metadados: %w", err)
+	}
+
+	if !checksum.ValidateBlockChecksum(data, expectedBlock.Hash) {
+		return errBlockMismatch
+	}
+
+	return nil
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
+
+	"github.com/zatta/tp2-p2p/internal/bitfield"
 )
 
 // Tipos de mensagens do protocolo P2P
@@ -15,6 +18,30 @@ const (
 	MsgTypeBlockData    = "BLOCK_DATA"
 	MsgTypePeerInfo     = "PEER_INFO"
 	MsgTypeError        = "ERROR"
+
+	// Troca de metadados a partir do info hash, em partes
+	MsgTypeRequestMetadata = "REQUEST_METADATA"
+	MsgTypeMetadata        = "METADATA"
+
+	// Extensões de metadados de conteúdo ao vivo
+	MsgTypeRequestExtension = "REQUEST_EXTENSION"
+	MsgTypeExtension        = "EXTENSION"
+
+	// Handshake de autenticação (HELLO -> CHALLENGE -> AUTH -> AUTH_OK)
+	MsgTypeHello     = "HELLO"
+	MsgTypeChallenge = "CHALLENGE"
+	MsgTypeAuth      = "AUTH"
+	MsgTypeAuthOK    = "AUTH_OK"
+)
+
+// Códigos de erro enviados em ErrorMsg
+const (
+	ErrCodeBlockUnavailable = "BLOCK_UNAVAILABLE" // Servidor não possui o bloco
+	ErrCodeBlockCorrupt     = "BLOCK_CORRUPT"     // Bloco local falhou na verificação
+	ErrCodeBusy             = "BUSY"              // Servidor atingiu o limite de conexões
+	ErrCodeAuthRequired     = "AUTH_REQUIRED"     // Servidor exige handshake antes de requisições
+	ErrCodeAuthFailed       = "AUTH_FAILED"       // Prova de identidade inválida ou peer não autorizado
+	ErrCodeUnknownSwarm     = "UNKNOWN_SWARM"     // Info hash não corresponde ao conteúdo servido
 )
 
 // Message é a interface base para todas as mensagens
@@ -32,41 +59,144 @@ func (m *RequestBlockMsg) GetType() string {
 	return m.Type
 }
 
-// RequestInfoMsg - Cliente solicita informações sobre blocos disponíveis
+// MetadataPieceSize é o tamanho de cada parte dos metadados em METADATA
+const MetadataPieceSize = 256 * 1024
+
+// RequestMetadataMsg - Cliente que só conhece o info hash pede uma parte dos
+// metadados serializados
+type RequestMetadataMsg struct {
+	Type     string `json:"type"`
+	InfoHash string `json:"info_hash"`
+	Piece    int    `json:"piece"`
+}
+
+func (m *RequestMetadataMsg) GetType() string {
+	return m.Type
+}
+
+// MetadataMsg - Servidor envia uma parte (de até MetadataPieceSize bytes) dos
+// metadados; TotalSize permite ao cliente saber quantas partes pedir
+type MetadataMsg struct {
+	Type      string `json:"type"`
+	InfoHash  string `json:"info_hash"`
+	Piece     int    `json:"piece"`
+	TotalSize int    `json:"total_size"`
+	Data      []byte `json:"data"`
+}
+
+func (m *MetadataMsg) GetType() string {
+	return m.Type
+}
+
+// RequestExtensionMsg - Cliente com metadados ao vivo na extensão Seq, com
+// FirstBlock blocos, pede os blocos publicados depois
+type RequestExtensionMsg struct {
+	Type       string `json:"type"`
+	InfoHash   string `json:"info_hash"`
+	Seq        int    `json:"seq"`
+	FirstBlock int    `json:"first_block"`
+}
+
+func (m *RequestExtensionMsg) GetType() string {
+	return m.Type
+}
+
+// ExtensionMsg - Servidor envia a extensão serializada (ver
+// metadata.Extension); Data vazio indica que não há extensão mais nova
+type ExtensionMsg struct {
+	Type     string `json:"type"`
+	InfoHash string `json:"info_hash"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+func (m *ExtensionMsg) GetType() string {
+	return m.Type
+}
+
+// Codificações de disponibilidade aceitas em PEER_INFO
+const (
+	AvailabilityList     = "list"     // lista JSON de IDs (formato original)
+	AvailabilityBitfield = "bitfield" // um bit por bloco, MSB primeiro
+	AvailabilityRLE      = "rle"      // sequências alternadas em uvarint
+)
+
+// SupportedAvailabilityEncodings lista as codificações que este peer entende
+var SupportedAvailabilityEncodings = []string{AvailabilityBitfield, AvailabilityRLE}
+
+// RequestInfoMsg - Cliente solicita informações sobre blocos disponíveis.
+// Encodings anuncia codificações compactas aceitas; servidores antigos o
+// ignoram e respondem com a lista original. InfoHash identifica o swarm
+// desejado (vazio = qualquer).
 type RequestInfoMsg struct {
-	Type string `json:"type"`
+	Type      string   `json:"type"`
+	Encodings []string `json:"encodings,omitempty"`
+	InfoHash  string   `json:"info_hash,omitempty"`
 }
 
 func (m *RequestInfoMsg) GetType() string {
 	return m.Type
 }
 
-// BlockDataMsg - Servidor envia dados do bloco com checksum
+// BlockDataMsg - Servidor envia dados do bloco com checksum e, no modo
+// Merkle, os irmãos do caminho da folha até a raiz
 type BlockDataMsg struct {
-	Type     string `json:"type"`
-	BlockID  int    `json:"block_id"`
-	Data     []byte `json:"data"`
-	Checksum string `json:"checksum"`
+	Type     string   `json:"type"`
+	BlockID  int      `json:"block_id"`
+	Data     []byte   `json:"data"`
+	Checksum string   `json:"checksum"`
+	Proof    [][]byte `json:"proof,omitempty"`
 }
 
 func (m *BlockDataMsg) GetType() string {
 	return m.Type
 }
 
-// PeerInfoMsg - Servidor informa quais blocos possui
+// PeerInfoMsg - Servidor informa quais blocos possui. Sem Encoding, a
+// disponibilidade vem em AvailableBlocks; caso contrário, em Availability.
 type PeerInfoMsg struct {
 	Type            string `json:"type"`
 	AvailableBlocks []int  `json:"available_blocks"`
 	TotalBlocks     int    `json:"total_blocks"`
+	Encoding        string `json:"encoding,omitempty"`
+	Availability    []byte `json:"availability,omitempty"`
 }
 
 func (m *PeerInfoMsg) GetType() string {
 	return m.Type
 }
 
-// ErrorMsg - Mensagem de erro
+// Bitfield decodifica a disponibilidade anunciada, qualquer que seja a codificação
+func (m *PeerInfoMsg) Bitfield() (*bitfield.Bitfield, error) {
+	if m.TotalBlocks < 0 {
+		return nil, fmt.Errorf("total de blocos inválido: %d", m.TotalBlocks)
+	}
+
+	switch m.Encoding {
+	case "", AvailabilityList:
+		b := bitfield.New(m.TotalBlocks)
+		for _, id := range m.AvailableBlocks {
+			if id < 0 || id >= m.TotalBlocks {
+				return nil, fmt.Errorf("bloco %d fora do intervalo [0, %d)", id, m.TotalBlocks)
+			}
+			b.Set(id)
+		}
+		return b, nil
+
+	case AvailabilityBitfield:
+		return bitfield.FromBytes(m.Availability, m.TotalBlocks)
+
+	case AvailabilityRLE:
+		return bitfield.DecodeRuns(m.Availability, m.TotalBlocks)
+
+	default:
+		return nil, fmt.Errorf("codificação de disponibilidade desconhecida: %s", m.Encoding)
+	}
+}
+
+// ErrorMsg - Mensagem de erro (Code é opcional e permite tratamento tipado)
 type ErrorMsg struct {
 	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 }
 
@@ -74,6 +204,67 @@ func (m *ErrorMsg) GetType() string {
 	return m.Type
 }
 
+// Error permite tratar um ErrorMsg recebido como error
+func (m *ErrorMsg) Error() string {
+	if m.Code != "" {
+		return fmt.Sprintf("%s: %s", m.Code, m.Message)
+	}
+	return m.Message
+}
+
+// HelloMsg - Cliente inicia o handshake com sua identidade (opcional), o
+// swarm desejado e um nonce
+type HelloMsg struct {
+	Type      string `json:"type"`
+	PeerID    string `json:"peer_id,omitempty"`
+	InfoHash  string `json:"info_hash,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+	Nonce     []byte `json:"nonce"`
+	Encrypt   bool   `json:"encrypt,omitempty"` // Solicita cifragem do stream
+}
+
+func (m *HelloMsg) GetType() string {
+	return m.Type
+}
+
+// ChallengeMsg - Servidor prova sua identidade e/ou a posse da chave do swarm,
+// informa o swarm que serve (apenas sem chave do swarm, para não revelá-lo a
+// quem não a possui) e desafia o cliente com um nonce
+type ChallengeMsg struct {
+	Type      string `json:"type"`
+	PeerID    string `json:"peer_id,omitempty"`
+	InfoHash  string `json:"info_hash,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+	Nonce     []byte `json:"nonce"`
+	Signature []byte `json:"signature,omitempty"`
+	SwarmMAC  []byte `json:"swarm_mac,omitempty"`
+}
+
+func (m *ChallengeMsg) GetType() string {
+	return m.Type
+}
+
+// AuthMsg - Cliente responde ao desafio assinando a transcrição do handshake
+// e/ou autenticando-a com a chave do swarm
+type AuthMsg struct {
+	Type      string `json:"type"`
+	Signature []byte `json:"signature,omitempty"`
+	SwarmMAC  []byte `json:"swarm_mac,omitempty"`
+}
+
+func (m *AuthMsg) GetType() string {
+	return m.Type
+}
+
+// AuthOKMsg - Servidor confirma o handshake
+type AuthOKMsg struct {
+	Type string `json:"type"`
+}
+
+func (m *AuthOKMsg) GetType() string {
+	return m.Type
+}
+
 // SendMessage envia uma mensagem via TCP
 // Formato: [4 bytes tamanho][payload JSON]
 func SendMessage(conn net.Conn, msg Message) error {
@@ -100,6 +291,32 @@ func SendMessage(conn net.Conn, msg Message) error {
 // ReceiveMessage recebe uma mensagem via TCP
 // Retorna um map[string]interface{} com os dados da mensagem
 func ReceiveMessage(conn net.Conn) (map[string]interface{}, error) {
+	return receiveMessage(conn, nil)
+}
+
+// ReceiveMessageWithDeadlines recebe uma mensagem aplicando prazos de leitura:
+// idleTimeout limita a espera pelo cabeçalho e readTimeout o tempo para receber
+// o payload depois que a mensagem começou a chegar. Zero desativa o prazo.
+func ReceiveMessageWithDeadlines(conn net.Conn, idleTimeout, readTimeout time.Duration) (map[string]interface{}, error) {
+	if err := conn.SetReadDeadline(deadline(idleTimeout)); err != nil {
+		return nil, fmt.Errorf("erro ao definir prazo de leitura: %w", err)
+	}
+
+	return receiveMessage(conn, func() error {
+		return conn.SetReadDeadline(deadline(readTimeout))
+	})
+}
+
+// deadline converte um timeout em prazo absoluto (zero = sem prazo)
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// receiveMessage lê uma mensagem; afterHeader é chamado após ler o tamanho
+func receiveMessage(conn net.Conn, afterHeader func() error) (map[string]interface{}, error) {
 	// Lê tamanho (4 bytes)
 	var size uint32
 	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
@@ -111,6 +328,12 @@ func ReceiveMessage(conn net.Conn) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("mensagem muito grande: %d bytes", size)
 	}
 
+	if afterHeader != nil {
+		if err := afterHeader(); err != nil {
+			return nil, fmt.Errorf("erro ao definir prazo de leitura: %w", err)
+		}
+	}
+
 	// Lê payload
 	data := make([]byte, size)
 	if _, err := io.ReadFull(conn, data); err != nil {
@@ -168,6 +391,34 @@ func ParseMessage(data map[string]interface{}) (Message, error) {
 		}
 		return &msg, nil
 
+	case MsgTypeRequestMetadata:
+		var msg RequestMetadataMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeMetadata:
+		var msg MetadataMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeRequestExtension:
+		var msg RequestExtensionMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeExtension:
+		var msg ExtensionMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
 	case MsgTypeError:
 		var msg ErrorMsg
 		if err := json.Unmarshal(jsonData, &msg); err != nil {
@@ -175,6 +426,34 @@ func ParseMessage(data map[string]interface{}) (Message, error) {
 		}
 		return &msg, nil
 
+	case MsgTypeHello:
+		var msg HelloMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeChallenge:
+		var msg ChallengeMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeAuth:
+		var msg AuthMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
+	case MsgTypeAuthOK:
+		var msg AuthOKMsg
+		if err := json.Unmarshal(jsonData, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+
 	default:
 		return nil, fmt.Errorf("tipo de mensagem desconhecido: %s", msgType)
 	}
@@ -189,9 +468,49 @@ func NewRequestBlock(blockID int) *RequestBlockMsg {
 }
 
 // NewRequestInfo cria uma mensagem de solicitação de informações
-func NewRequestInfo() *RequestInfoMsg {
+func NewRequestInfo(encodings ...string) *RequestInfoMsg {
 	return &RequestInfoMsg{
-		Type: MsgTypeRequestInfo,
+		Type:      MsgTypeRequestInfo,
+		Encodings: encodings,
+	}
+}
+
+// NewRequestMetadata cria o pedido de uma parte dos metadados
+func NewRequestMetadata(infoHash string, piece int) *RequestMetadataMsg {
+	return &RequestMetadataMsg{
+		Type:     MsgTypeRequestMetadata,
+		InfoHash: infoHash,
+		Piece:    piece,
+	}
+}
+
+// NewMetadata cria a mensagem com uma parte dos metadados
+func NewMetadata(infoHash string, piece int, totalSize int, data []byte) *MetadataMsg {
+	return &MetadataMsg{
+		Type:      MsgTypeMetadata,
+		InfoHash:  infoHash,
+		Piece:     piece,
+		TotalSize: totalSize,
+		Data:      data,
+	}
+}
+
+// NewRequestExtension cria o pedido dos blocos publicados após a extensão seq
+func NewRequestExtension(infoHash string, seq, firstBlock int) *RequestExtensionMsg {
+	return &RequestExtensionMsg{
+		Type:       MsgTypeRequestExtension,
+		InfoHash:   infoHash,
+		Seq:        seq,
+		FirstBlock: firstBlock,
+	}
+}
+
+// NewExtension cria a mensagem com uma extensão serializada (nil = nada novo)
+func NewExtension(infoHash string, data []byte) *ExtensionMsg {
+	return &ExtensionMsg{
+		Type:     MsgTypeExtension,
+		InfoHash: infoHash,
+		Data:     data,
 	}
 }
 
@@ -214,6 +533,39 @@ func NewPeerInfo(availableBlocks []int, totalBlocks int) *PeerInfoMsg {
 	}
 }
 
+// NewPeerInfoEncoded cria um PEER_INFO com a menor codificação aceita pelo
+// cliente, recorrendo à lista original quando nenhuma compacta é aceita
+func NewPeerInfoEncoded(available *bitfield.Bitfield, accepted []string) *PeerInfoMsg {
+	var best *PeerInfoMsg
+
+	for _, encoding := range accepted {
+		var data []byte
+		switch encoding {
+		case AvailabilityBitfield:
+			data = available.Bytes()
+		case AvailabilityRLE:
+			data = available.EncodeRuns()
+		default:
+			continue
+		}
+
+		if best == nil || len(data) < len(best.Availability) {
+			best = &PeerInfoMsg{
+				Type:         MsgTypePeerInfo,
+				TotalBlocks:  available.Len(),
+				Encoding:     encoding,
+				Availability: data,
+			}
+		}
+	}
+
+	if best == nil {
+		return NewPeerInfo(available.Indices(), available.Len())
+	}
+
+	return best
+}
+
 // NewError cria uma mensagem de erro
 func NewError(message string) *ErrorMsg {
 	return &ErrorMsg{
@@ -221,3 +573,53 @@ func NewError(message string) *ErrorMsg {
 		Message: message,
 	}
 }
+
+// NewErrorWithCode cria uma mensagem de erro tipada
+func NewErrorWithCode(code string, message string) *ErrorMsg {
+	return &ErrorMsg{
+		Type:    MsgTypeError,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// NewHello cria a mensagem inicial do handshake
+func NewHello(peerID string, infoHash string, publicKey []byte, nonce []byte, encrypt bool) *HelloMsg {
+	return &HelloMsg{
+		Type:      MsgTypeHello,
+		PeerID:    peerID,
+		InfoHash:  infoHash,
+		PublicKey: publicKey,
+		Nonce:     nonce,
+		Encrypt:   encrypt,
+	}
+}
+
+// NewChallenge cria a resposta do servidor ao HELLO
+func NewChallenge(peerID string, infoHash string, publicKey []byte, nonce []byte, signature []byte, swarmMAC []byte) *ChallengeMsg {
+	return &ChallengeMsg{
+		Type:      MsgTypeChallenge,
+		PeerID:    peerID,
+		InfoHash:  infoHash,
+		PublicKey: publicKey,
+		Nonce:     nonce,
+		Signature: signature,
+		SwarmMAC:  swarmMAC,
+	}
+}
+
+// NewAuth cria a resposta do cliente ao desafio
+func NewAuth(signature []byte, swarmMAC []byte) *AuthMsg {
+	return &AuthMsg{
+		Type:      MsgTypeAuth,
+		Signature: signature,
+		SwarmMAC:  swarmMAC,
+	}
+}
+
+// NewAuthOK cria a confirmação do handshake
+func NewAuthOK() *AuthOKMsg {
+	return &AuthOKMsg{
+		Type: MsgTypeAuthOK,
+	}
+}
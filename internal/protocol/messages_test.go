@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/zatta/tp2-p2p/internal/bitfield"
+)
+
+// roundTrip envia msg por uma conexão em memória e interpreta a mensagem recebida
+func roundTrip(t *testing.T, msg Message) Message {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- SendMessage(client, msg)
+	}()
+
+	data, err := ReceiveMessage(server)
+	if err != nil {
+		t.Fatalf("erro ao receber mensagem: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("erro ao enviar mensagem: %v", err)
+	}
+
+	parsed, err := ParseMessage(data)
+	if err != nil {
+		t.Fatalf("erro ao parsear mensagem: %v", err)
+	}
+	return parsed
+}
+
+func TestPeerInfoEncodings(t *testing.T) {
+	available := bitfield.New(1000)
+	for i := 0; i < 1000; i++ {
+		if i%50 != 7 {
+			available.Set(i)
+		}
+	}
+
+	for _, accepted := range [][]string{
+		nil,
+		{AvailabilityBitfield},
+		{AvailabilityRLE},
+		SupportedAvailabilityEncodings,
+	} {
+		sent := NewPeerInfoEncoded(available, accepted)
+		received, ok := roundTrip(t, sent).(*PeerInfoMsg)
+		if !ok {
+			t.Fatalf("%v: esperado PeerInfoMsg", accepted)
+		}
+
+		decoded, err := received.Bitfield()
+		if err != nil {
+			t.Fatalf("%v: %v", accepted, err)
+		}
+		if !bytes.Equal(decoded.Bytes(), available.Bytes()) {
+			t.Fatalf("%v (%s): disponibilidade decodificada difere da enviada", accepted, received.Encoding)
+		}
+	}
+}
+
+func TestPeerInfoPicksSmallestEncoding(t *testing.T) {
+	available := bitfield.New(100000)
+	available.SetAll()
+
+	msg := NewPeerInfoEncoded(available, SupportedAvailabilityEncodings)
+	if msg.Encoding != AvailabilityRLE {
+		t.Fatalf("codificação escolhida para arquivo completo: %s, esperado %s", msg.Encoding, AvailabilityRLE)
+	}
+}
+
+func TestPeerInfoRejectsInvalidAvailability(t *testing.T) {
+	cases := []*PeerInfoMsg{
+		{Type: MsgTypePeerInfo, TotalBlocks: 10, AvailableBlocks: []int{10}},
+		{Type: MsgTypePeerInfo, TotalBlocks: 10, Encoding: AvailabilityBitfield, Availability: []byte{0xff}},
+		{Type: MsgTypePeerInfo, TotalBlocks: 10, Encoding: AvailabilityRLE, Availability: []byte{0x0b}},
+		{Type: MsgTypePeerInfo, TotalBlocks: 10, Encoding: "desconhecida"},
+		{Type: MsgTypePeerInfo, TotalBlocks: -1},
+	}
+
+	for i, msg := range cases {
+		if _, err := msg.Bitfield(); err == nil {
+			t.Errorf("caso %d: disponibilidade inválida aceita", i)
+		}
+	}
+}
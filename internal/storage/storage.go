@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zatta/tp2-p2p/internal/checksum"
+)
+
+// Storage lê e grava blocos no espaço contínuo de bytes descrito pelos
+// metadados, seja ele um único arquivo ou uma árvore de arquivos
+type Storage interface {
+	// ReadBlock lê um bloco (o último pode ser menor que o tamanho de bloco)
+	ReadBlock(blockID int) ([]byte, error)
+
+	// WriteBlock grava um bloco na sua posição
+	WriteBlock(blockID int, data []byte) error
+
+	// NewReader abre o conteúdo completo na ordem dos blocos
+	NewReader() (io.ReadCloser, error)
+
+	// Create prepara arquivos vazios com os tamanhos corretos (para leechers)
+	Create() error
+}
+
+// Growable é um armazenamento cujo conteúdo pode crescer (conteúdo ao vivo)
+type Growable interface {
+	// Grow passa a aceitar blocos até size bytes, posicionados por layout
+	Grow(size int64, layout Layout) error
+}
+
+// Layout localiza os blocos no espaço contínuo de bytes
+type Layout interface {
+	// Block retorna a posição e o tamanho de um bloco
+	Block(blockID int) (offset int64, length int, err error)
+}
+
+// FixedLayout divide o conteúdo em blocos de BlockSize bytes; o último pode
+// ser menor
+type FixedLayout struct {
+	Size      int64
+	BlockSize int
+}
+
+// Block calcula a posição do bloco a partir do seu ID
+func (l FixedLayout) Block(blockID int) (int64, int, error) {
+	offset := int64(blockID) * int64(l.BlockSize)
+	if blockID < 0 || offset >= l.Size {
+		return 0, 0, fmt.Errorf("bloco %d fora do conteúdo (%d bytes)", blockID, l.Size)
+	}
+
+	length := int64(l.BlockSize)
+	if offset+length > l.Size {
+		length = l.Size - offset
+	}
+
+	return offset, int(length), nil
+}
+
+// Extent é a posição e o tamanho de um bloco
+type Extent struct {
+	Offset int64
+	Size   int
+}
+
+// ExtentLayout lista a posição de cada bloco (blocos de tamanho variável)
+type ExtentLayout []Extent
+
+// Block retorna a posição declarada do bloco
+func (l ExtentLayout) Block(blockID int) (int64, int, error) {
+	if blockID < 0 || blockID >= len(l) {
+		return 0, 0, fmt.Errorf("bloco %d fora do conteúdo (%d blocos)", blockID, len(l))
+	}
+	return l[blockID].Offset, l[blockID].Size, nil
+}
+
+// File descreve um arquivo de uma árvore compartilhada
+type File struct {
+	Path string // relativo à raiz, separado por "/"
+	Size int64
+	Mode os.FileMode
+}
+
+// SingleFile armazena os blocos em um único arquivo
+type SingleFile struct {
+	path string
+
+	mu     sync.RWMutex // protege size e layout, que mudam em Grow
+	size   int64
+	layout Layout
+}
+
+// NewSingleFile cria o armazenamento de um único arquivo
+func NewSingleFile(path string, size int64, layout Layout) *SingleFile {
+	return &SingleFile{
+		path:   path,
+		size:   size,
+		layout: layout,
+	}
+}
+
+// ReadBlock lê um bloco do arquivo
+func (s *SingleFile) ReadBlock(blockID int) ([]byte, error) {
+	offset, length, err := s.block(blockID)
+	if err != nil {
+		return nil, err
+	}
+	return checksum.ReadRangeFromFile(s.path, offset, length)
+}
+
+// WriteBlock grava um bloco no arquivo
+func (s *SingleFile) WriteBlock(blockID int, data []byte) error {
+	offset, length, err := s.block(blockID)
+	if err != nil {
+		return err
+	}
+	if len(data) != length {
+		return fmt.Errorf("bloco %d com %d bytes, esperado %d", blockID, len(data), length)
+	}
+	return checksum.WriteRangeToFile(s.path, offset, data)
+}
+
+// NewReader abre o arquivo para leitura sequencial
+func (s *SingleFile) NewReader() (io.ReadCloser, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir arquivo: %w", err)
+	}
+	return file, nil
+}
+
+// Create cria o arquivo vazio com o tamanho final
+func (s *SingleFile) Create() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return createSized(s.path, s.size, 0644)
+}
+
+// Grow aumenta o conteúdo para size bytes. O arquivo só é estendido se for
+// menor (o do publicador já contém os dados novos); nunca é truncado.
+func (s *SingleFile) Grow(size int64, layout Layout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if size < s.size {
+		return fmt.Errorf("conteúdo não pode encolher de %d para %d bytes", s.size, size)
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("erro ao acessar arquivo: %w", err)
+	}
+	if info.Size() < size {
+		if err := os.Truncate(s.path, size); err != nil {
+			return fmt.Errorf("erro ao definir tamanho do arquivo: %w", err)
+		}
+	}
+
+	s.size = size
+	s.layout = layout
+	return nil
+}
+
+// block localiza um bloco com o layout atual
+func (s *SingleFile) block(blockID int) (int64, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.layout.Block(blockID)
+}
+
+// MultiFile mapeia o espaço contínuo de blocos sobre uma árvore de arquivos,
+// concatenados na ordem em que aparecem nos metadados
+type MultiFile struct {
+	root    string
+	files   []File
+	offsets []int64 // posição de cada arquivo no espaço de blocos
+	size    int64
+	layout  Layout
+}
+
+// NewMultiFile cria o armazenamento de uma árvore de arquivos sob root.
+// Caminhos absolutos ou que escapam de root são recusados.
+func NewMultiFile(root string, files []File, layout Layout) (*MultiFile, error) {
+	m := &MultiFile{
+		root:    root,
+		files:   files,
+		offsets: make([]int64, len(files)),
+		layout:  layout,
+	}
+
+	for i, f := range files {
+		if err := CheckPath(f.Path); err != nil {
+			return nil, err
+		}
+		if f.Size < 0 {
+			return nil, fmt.Errorf("arquivo %s com tamanho negativo", f.Path)
+		}
+		m.offsets[i] = m.size
+		m.size += f.Size
+	}
+
+	return m, nil
+}
+
+// CheckPath verifica se o caminho é relativo e não sai da raiz
+func CheckPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("caminho vazio")
+	}
+	if strings.Contains(path, "\\") || filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return fmt.Errorf("caminho não relativo: %s", path)
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("caminho inseguro: %s", path)
+		}
+	}
+	return nil
+}
+
+// Offsets retorna a posição de cada arquivo no espaço de blocos
+func (m *MultiFile) Offsets() []int64 {
+	return append([]int64(nil), m.offsets...)
+}
+
+// ReadBlock lê um bloco, juntando os trechos dos arquivos que ele cobre
+func (m *MultiFile) ReadBlock(blockID int) ([]byte, error) {
+	offset, length, err := m.layout.Block(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	err = m.forEachSegment(offset, length, func(f File, fileOffset int64, start, end int) error {
+		file, err := os.Open(m.fullPath(f))
+		if err != nil {
+			return fmt.Errorf("erro ao abrir arquivo: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := file.ReadAt(data[start:end], fileOffset); err != nil {
+			return fmt.Errorf("erro ao ler %s: %w", f.Path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// WriteBlock grava um bloco, distribuindo-o pelos arquivos que ele cobre
+func (m *MultiFile) WriteBlock(blockID int, data []byte) error {
+	offset, length, err := m.layout.Block(blockID)
+	if err != nil {
+		return err
+	}
+	if len(data) != length {
+		return fmt.Errorf("bloco %d com %d bytes, esperado %d", blockID, len(data), length)
+	}
+
+	return m.forEachSegment(offset, length, func(f File, fileOffset int64, start, end int) error {
+		file, err := os.OpenFile(m.fullPath(f), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("erro ao abrir arquivo: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := file.WriteAt(data[start:end], fileOffset); err != nil {
+			return fmt.Errorf("erro ao escrever %s: %w", f.Path, err)
+		}
+		return nil
+	})
+}
+
+// NewReader abre os arquivos em sequência, como um único stream
+func (m *MultiFile) NewReader() (io.ReadCloser, error) {
+	return &multiReader{m: m}, nil
+}
+
+// Create cria a árvore de diretórios e os arquivos vazios com seus tamanhos e permissões
+func (m *MultiFile) Create() error {
+	if err := os.MkdirAll(m.root, 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório: %w", err)
+	}
+
+	for _, f := range m.files {
+		path := m.fullPath(f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("erro ao criar diretório: %w", err)
+		}
+
+		mode := f.Mode.Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := createSized(path, f.Size, mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEachSegment chama fn para cada trecho de arquivo coberto por
+// [offset, offset+length); start e end delimitam o trecho no buffer do bloco
+func (m *MultiFile) forEachSegment(offset int64, length int, fn func(f File, fileOffset int64, start, end int) error) error {
+	end := offset + int64(length)
+
+	for i, f := range m.files {
+		fileStart := m.offsets[i]
+		fileEnd := fileStart + f.Size
+		if fileEnd <= offset || f.Size == 0 {
+			continue
+		}
+		if fileStart >= end {
+			break
+		}
+
+		segStart := max(offset, fileStart)
+		segEnd := min(end, fileEnd)
+		if err := fn(f, segStart-fileStart, int(segStart-offset), int(segEnd-offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fullPath converte o caminho relativo para o sistema de arquivos local
+func (m *MultiFile) fullPath(f File) string {
+	return filepath.Join(m.root, filepath.FromSlash(f.Path))
+}
+
+// multiReader lê os arquivos da árvore em sequência, abrindo um por vez
+type multiReader struct {
+	m       *MultiFile
+	next    int
+	current *os.File
+}
+
+func (r *multiReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next >= len(r.m.files) {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.m.fullPath(r.m.files[r.next]))
+			if err != nil {
+				return 0, fmt.Errorf("erro ao abrir arquivo: %w", err)
+			}
+			r.current = file
+			r.next++
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *multiReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// createSized cria (ou trunca) um arquivo com o tamanho e as permissões informados
+func createSized(path string, size int64, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("erro ao criar arquivo: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("erro ao definir tamanho do arquivo: %w", err)
+	}
+
+	// OpenFile não altera permissões de arquivos que já existiam
+	if err := file.Chmod(mode); err != nil {
+		return fmt.Errorf("erro ao definir permissões do arquivo: %w", err)
+	}
+
+	return nil
+}
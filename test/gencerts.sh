@@ -0,0 +1,59 @@
+#!/bin/bash
+
+# Script para gerar uma CA interna e certificados de peers para testes com TLS
+
+set -e  # Para em caso de erro
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+CERTS_DIR="$SCRIPT_DIR/certs"
+DAYS=365
+
+# Peers que recebem certificado (padrão: peer_a a peer_d)
+PEERS="${@:-peer_a peer_b peer_c peer_d}"
+
+if ! command -v openssl >/dev/null 2>&1; then
+    echo "Erro: openssl não encontrado"
+    exit 1
+fi
+
+mkdir -p "$CERTS_DIR"
+
+echo "========================================"
+echo "  Gerando Certificados de Teste"
+echo "========================================"
+echo ""
+
+# CA auto-assinada
+if [ ! -f "$CERTS_DIR/ca.pem" ]; then
+    echo "Gerando CA interna..."
+    openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:prime256v1 -nodes \
+        -keyout "$CERTS_DIR/ca.key" -out "$CERTS_DIR/ca.pem" \
+        -days $DAYS -subj "/CN=p2p-test-ca" 2>/dev/null
+else
+    echo "CA existente reutilizada: $CERTS_DIR/ca.pem"
+fi
+
+# Certificado de cada peer, válido para servidor e cliente
+for PEER in $PEERS; do
+    echo "Gerando certificado de $PEER..."
+    openssl req -newkey ec -pkeyopt ec_paramgen_curve:prime256v1 -nodes \
+        -keyout "$CERTS_DIR/$PEER.key" -out "$CERTS_DIR/$PEER.csr" \
+        -subj "/CN=$PEER" 2>/dev/null
+
+    openssl x509 -req -in "$CERTS_DIR/$PEER.csr" \
+        -CA "$CERTS_DIR/ca.pem" -CAkey "$CERTS_DIR/ca.key" -CAcreateserial \
+        -out "$CERTS_DIR/$PEER.pem" -days $DAYS \
+        -extfile <(printf "extendedKeyUsage=serverAuth,clientAuth\nsubjectAltName=DNS:localhost,IP:127.0.0.1") 2>/dev/null
+
+    rm -f "$CERTS_DIR/$PEER.csr"
+done
+
+echo ""
+echo "Certificados criados em: $CERTS_DIR"
+echo ""
+echo "Exemplo de configuração do peer:"
+echo '  "tls": {'
+echo '    "cert_file": "'"$CERTS_DIR"'/peer_a.pem",'
+echo '    "key_file": "'"$CERTS_DIR"'/peer_a.key",'
+echo '    "ca_file": "'"$CERTS_DIR"'/ca.pem"'
+echo '  }'